@@ -3,9 +3,17 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"strings"
-	"pflag"
+
+	"main/utils/ampapi"
+	"main/utils/naming"
+	"main/utils/safepath"
+	"main/utils/structs"
+	"main/utils/tools"
+
+	"github.com/spf13/pflag"
 )
 
 func main() {
@@ -14,23 +22,21 @@ func main() {
 		fmt.Printf("load Config failed: %v", err)
 		return
 	}
-	token, err := ampapi.GetToken()
-	if err != nil {
-		if Config.AuthorizationToken != "" && Config.AuthorizationToken != "your-authorization-token" {
-			token = strings.Replace(Config.AuthorizationToken, "Bearer ", "", -1)
-		} else {
-			fmt.Println("Failed to get token.")
-			return
-		}
-	}
 	var search_type string
-	pflag.StringVar(&search_type, "search", "", "Search for 'album', 'song', or 'artist'. Provide query after flags.")
+	var search_json, search_first bool
+	var search_pick int
+	pflag.StringVar(&search_type, "search", "", "Search for 'album', 'song', 'artist', 'playlist', 'music-video', 'station', 'record-label', 'apple-curator' or 'top'. Provide query after flags.")
+	pflag.BoolVar(&search_json, "json", false, "With --search, print the results as JSON instead of prompting")
+	pflag.IntVar(&search_pick, "pick", 0, "With --search, download result N without prompting")
+	pflag.BoolVar(&search_first, "first", false, "With --search, download the first result without prompting (same as --pick 1)")
 	pflag.BoolVar(&dl_atmos, "atmos", false, "Enable atmos download mode")
 	pflag.BoolVar(&dl_aac, "aac", false, "Enable adm-aac download mode")
 	pflag.BoolVar(&dl_select, "select", false, "Enable selective download")
 	pflag.BoolVar(&dl_song, "song", false, "Enable single song download mode")
 	pflag.BoolVar(&artist_select, "all-album", false, "Download all artist albums")
 	pflag.BoolVar(&debug_mode, "debug", false, "Enable debug mode to show audio quality information")
+	pflag.BoolVar(&retag_move, "move", false, "With retag, also move files to the current folder and file name formats")
+	pflag.BoolVar(&lyrics_force, "force", false, "With lyrics fetch, replace lyrics that files already have")
 	alac_max = pflag.Int("alac-max", Config.AlacMax, "Specify the max quality for download alac")
 	atmos_max = pflag.Int("atmos-max", Config.AtmosMax, "Specify the max quality for download atmos")
 	aac_type = pflag.String("aac-type", Config.AacType, "Select AAC type, aac aac-binaural aac-downmix")
@@ -39,7 +45,15 @@ func main() {
 
 	pflag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options] [url1 url2 ...]\n", "[cli_main | cli_main.exe | go run cli_main.go]")
-		fmt.Fprintf(os.Stderr, "Search Usage: %s --search [album|song|artist] [query]\n", "[cli_main | cli_main.exe | go run cli_main.go]")
+		fmt.Fprintf(os.Stderr, "Search Usage: %s --search [album|song|artist|playlist|music-video|station|record-label|apple-curator|top] [query]\n", "[cli_main | cli_main.exe | go run cli_main.go]")
+		fmt.Fprintf(os.Stderr, "Check external tools: %s doctor\n", "[cli_main | cli_main.exe | go run cli_main.go]")
+		fmt.Fprintf(os.Stderr, "Sync playlists: %s playlist sync [playlist-url ...]\n", "[cli_main | cli_main.exe | go run cli_main.go]")
+		fmt.Fprintf(os.Stderr, "Index a library: %s library scan [folder ...]\n", "[cli_main | cli_main.exe | go run cli_main.go]")
+		fmt.Fprintf(os.Stderr, "List missing tracks: %s library missing [album-url ...]\n", "[cli_main | cli_main.exe | go run cli_main.go]")
+		fmt.Fprintf(os.Stderr, "Upgrade library quality: %s upgrade [folder ...]\n", "[cli_main | cli_main.exe | go run cli_main.go]")
+		fmt.Fprintf(os.Stderr, "Verify checksums: %s verify [folder ...]\n", "[cli_main | cli_main.exe | go run cli_main.go]")
+		fmt.Fprintf(os.Stderr, "Refresh tags: %s [--move] retag [file | folder | album-url ...]\n", "[cli_main | cli_main.exe | go run cli_main.go]")
+		fmt.Fprintf(os.Stderr, "Add lyrics to a library: %s [--force] lyrics fetch [file | folder ...]\n", "[cli_main | cli_main.exe | go run cli_main.go]")
 		fmt.Println("\nOptions:")
 		pflag.PrintDefaults()
 	}
@@ -53,22 +67,162 @@ func main() {
 
 	args := pflag.Args()
 
+	if len(args) > 0 && args[0] == "doctor" {
+		if !runDoctor() {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "library" {
+		if len(args) < 3 || (args[1] != "scan" && args[1] != "missing") {
+			fmt.Println("Error: usage is library scan [folder ...] or library missing [album-url ...]")
+			return
+		}
+		if args[1] == "scan" {
+			if err := runLibraryScan(args[2:]); err != nil {
+				fmt.Println("Library scan failed:", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	if len(args) > 0 && args[0] == "verify" {
+		if len(args) < 2 {
+			fmt.Println("Error: usage is verify [folder ...]")
+			return
+		}
+		problems, err := runVerify(args[1:])
+		if err != nil {
+			fmt.Println("Verify failed:", err)
+			os.Exit(1)
+		}
+		printReport()
+		if problems > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	token, err := ampapi.GetToken()
+	if err != nil {
+		if Config.AuthorizationToken != "" && Config.AuthorizationToken != "your-authorization-token" {
+			token = strings.Replace(Config.AuthorizationToken, "Bearer ", "", -1)
+		} else {
+			fmt.Println("Failed to get token.")
+			return
+		}
+	}
+
+	if len(args) > 0 && args[0] == "library" {
+		for _, urlRaw := range args[2:] {
+			storefront, albumId := checkUrl(urlRaw)
+			if albumId == "" {
+				fmt.Println("Invalid album URL:", urlRaw)
+				continue
+			}
+			if _, err := runLibraryMissing(albumId, storefront, token); err != nil {
+				fmt.Println("Failed to check album:", err)
+			}
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "upgrade" {
+		if err := runUpgrade(args[1:], token); err != nil {
+			fmt.Println("Upgrade failed:", err)
+		}
+		fmt.Printf("=======  [\u2714 ] Completed: %d/%d  |  [\u26A0 ] Warnings: %d  |  [\u2716 ] Errors: %d  =======\n", counter.Success, counter.Total, counter.Unavailable+counter.NotSong, counter.Error)
+		printReport()
+		saveHistory()
+		return
+	}
+
+	if len(args) > 0 && args[0] == "retag" {
+		if len(args) < 2 {
+			fmt.Println("Error: usage is retag [file | folder | album-url ...]")
+			return
+		}
+		if err := runRetag(args[1:], token, Config.MediaUserToken); err != nil {
+			fmt.Println("Retag failed:", err)
+		}
+		fmt.Printf("=======  [\u2714 ] Completed: %d/%d  |  [\u26A0 ] Warnings: %d  |  [\u2716 ] Errors: %d  =======\n", counter.Success, counter.Total, counter.Unavailable+counter.NotSong, counter.Error)
+		printReport()
+		saveHistory()
+		return
+	}
+
+	if len(args) > 0 && args[0] == "lyrics" {
+		if len(args) < 3 || args[1] != "fetch" {
+			fmt.Println("Error: usage is lyrics fetch [file | folder ...]")
+			return
+		}
+		if err := runLyricsFetch(args[2:], token, Config.MediaUserToken); err != nil {
+			fmt.Println("Lyrics fetch failed:", err)
+		}
+		fmt.Printf("=======  [\u2714 ] Completed: %d/%d  |  [\u26A0 ] Warnings: %d  |  [\u2716 ] Errors: %d  =======\n", counter.Success, counter.Total, counter.Unavailable+counter.NotSong, counter.Error)
+		printReport()
+		saveHistory()
+		return
+	}
+
+	if len(args) > 0 && args[0] == "playlist" {
+		if len(args) < 3 || args[1] != "sync" {
+			fmt.Println("Error: usage is playlist sync [playlist-url ...]")
+			return
+		}
+		if dl_select {
+			fmt.Println("Error: --select cannot be used with playlist sync, which keeps the whole playlist in step")
+			return
+		}
+		for _, urlRaw := range args[2:] {
+			storefront, playlistId := checkUrlPlaylist(urlRaw)
+			if playlistId == "" {
+				fmt.Println("Invalid playlist URL:", urlRaw)
+				continue
+			}
+			if err := syncPlaylist(playlistId, token, storefront, Config.MediaUserToken); err != nil {
+				fmt.Println("Failed to sync playlist:", err)
+			}
+		}
+		fmt.Printf("=======  [\u2714 ] Completed: %d/%d  |  [\u26A0 ] Warnings: %d  |  [\u2716 ] Errors: %d  =======\n", counter.Success, counter.Total, counter.Unavailable+counter.NotSong, counter.Error)
+		printReport()
+		saveHistory()
+		return
+	}
+
 	if search_type != "" {
 		if len(args) == 0 {
 			fmt.Println("Error: --search flag requires a query.")
 			pflag.Usage()
 			return
 		}
-		selectedUrl, err := handleSearch(search_type, args, token)
-		if err != nil {
-			fmt.Printf("\nSearch process failed: %v\n", err)
-			return
+		if search_first {
+			search_pick = 1
 		}
-		if selectedUrl == "" {
-			fmt.Println("\nExiting.")
-			return
+		if search_json || search_pick != 0 {
+			// quality comes from --atmos and --aac instead of a prompt
+			selectedUrl, err := searchPick(search_type, args, token, search_json, search_pick)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Search failed: %v\n", err)
+				os.Exit(1)
+			}
+			if selectedUrl == "" {
+				return
+			}
+			os.Args = []string{selectedUrl}
+		} else {
+			selectedUrl, err := handleSearch(search_type, args, token)
+			if err != nil {
+				fmt.Printf("\nSearch process failed: %v\n", err)
+				return
+			}
+			if selectedUrl == "" {
+				fmt.Println("\nExiting.")
+				return
+			}
+			os.Args = []string{selectedUrl}
 		}
-		os.Args = []string{selectedUrl}
 	} else {
 		if len(args) == 0 {
 			fmt.Println("No URLs provided. Please provide at least one URL.")
@@ -84,10 +238,7 @@ func main() {
 			fmt.Println("Failed to get artistname.")
 			return
 		}
-		Config.ArtistFolderFormat = strings.NewReplacer(
-			"{UrlArtistName}", LimitString(urlArtistName),
-			"{ArtistId}", urlArtistID,
-		).Replace(Config.ArtistFolderFormat)
+		urlArtist = naming.Artist{ID: urlArtistID, URLName: urlArtistName}
 		albumArgs, err := checkArtist(os.Args[0], token, "albums")
 		if err != nil {
 			fmt.Println("Failed to get artist albums.")
@@ -98,6 +249,20 @@ func main() {
 			fmt.Println("Failed to get artist music-videos.")
 		}
 		os.Args = append(albumArgs, mvArgs...)
+	} else if strings.Contains(os.Args[0], "/label/") {
+		albumArgs, err := checkLabel(os.Args[0], token)
+		if err != nil {
+			fmt.Println("Failed to get label releases.")
+			return
+		}
+		os.Args = albumArgs
+	} else if strings.Contains(os.Args[0], "/curator/") {
+		playlistArgs, err := checkCurator(os.Args[0], token)
+		if err != nil {
+			fmt.Println("Failed to get curator playlists.")
+			return
+		}
+		os.Args = playlistArgs
 	}
 	albumTotal := len(os.Args)
 	for {
@@ -116,18 +281,14 @@ func main() {
 					counter.Success++
 					continue
 				}
-				if _, err := exec.LookPath("mp4decrypt"); err != nil {
-					fmt.Println(": mp4decrypt is not found, skip MV dl")
+				if !tools.Default.Mp4Decrypt.Status().OK() {
+					fmt.Println(": mp4decrypt is not usable, skip MV dl")
 					counter.Success++
 					continue
 				}
-				mvSaveDir := strings.NewReplacer(
-					"{ArtistName}", "",
-					"{UrlArtistName}", "",
-					"{ArtistId}", "",
-				).Replace(Config.ArtistFolderFormat)
+				mvSaveDir := nameFormats.Artist.ExecuteOr(naming.Data{}, "")
 				if mvSaveDir != "" {
-					mvSaveDir = filepath.Join(Config.AlacSaveFolder, forbiddenNames.ReplaceAllString(mvSaveDir, "_"))
+					mvSaveDir = safepath.Join(Config.AlacSaveFolder, mvSaveDir)
 				} else {
 					mvSaveDir = Config.AlacSaveFolder
 				}
@@ -190,6 +351,8 @@ func main() {
 			}
 		}
 		fmt.Printf("=======  [\u2714 ] Completed: %d/%d  |  [\u26A0 ] Warnings: %d  |  [\u2716 ] Errors: %d  =======\n", counter.Success, counter.Total, counter.Unavailable+counter.NotSong, counter.Error)
+		printReport()
+		saveHistory()
 		if counter.Error == 0 {
 			break
 		}
@@ -197,5 +360,6 @@ func main() {
 		fmt.Scanln()
 		fmt.Println("Start trying again...")
 		counter = structs.Counter{}
+		jobReport.Reset()
 	}
 } 
\ No newline at end of file
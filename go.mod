@@ -9,6 +9,7 @@ require (
 	github.com/grafov/m3u8 v0.11.1
 	github.com/schollz/progressbar/v3 v3.14.6
 	github.com/spf13/pflag v1.0.5
+	golang.org/x/text v0.21.0
 	google.golang.org/protobuf v1.36.2
 	lukechampine.com/frand v1.5.1
 )
@@ -92,13 +93,11 @@ require (
 	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/term v0.28.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
 	golang.org/x/tools v0.29.0 // indirect
 )
 
 require (
 	github.com/AlecAivazis/survey/v2 v2.3.7
-	github.com/beevik/etree v1.3.0
 	github.com/fatih/color v1.18.0
 	github.com/olekukonko/tablewriter v0.0.5
 	github.com/zhaarey/go-mp4tag v0.0.0-20250210094042-22578afc09bf
@@ -3,15 +3,17 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"io"
+	"io/fs"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -20,11 +22,22 @@ import (
 	"time"
 
 	"main/utils/ampapi"
+	"main/utils/genre"
+	"main/utils/history"
+	"main/utils/library"
 	"main/utils/lyrics"
+	"main/utils/manifest"
+	"main/utils/naming"
+	"main/utils/nfo"
+	"main/utils/playlistfile"
+	"main/utils/playlistsync"
+	"main/utils/report"
 	"main/utils/runv2"
 	"main/utils/runv3"
+	"main/utils/safepath"
 	"main/utils/structs"
 	"main/utils/task"
+	"main/utils/tools"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/fatih/color"
@@ -35,21 +48,34 @@ import (
 )
 
 var (
-	forbiddenNames = regexp.MustCompile(`[/\\<>:"|?*]`)
-	dl_atmos       bool
-	dl_aac         bool
-	dl_select      bool
-	dl_song        bool
-	artist_select  bool
-	debug_mode     bool
-	alac_max       *int
-	atmos_max      *int
-	mv_max         *int
-	mv_audio_type  *string
-	aac_type       *string
-	Config         structs.ConfigSet
-	counter        structs.Counter
-	okDict         = make(map[string][]int)
+	dl_atmos      bool
+	dl_aac        bool
+	dl_select     bool
+	dl_song       bool
+	artist_select bool
+	debug_mode    bool
+	retag_move    bool
+	lyrics_force  bool
+	alac_max      *int
+	atmos_max     *int
+	mv_max        *int
+	mv_audio_type *string
+	aac_type      *string
+	Config        structs.ConfigSet
+	counter       structs.Counter
+	okDict        = make(map[string][]int)
+	jobReport     report.Report
+	historyStore  *history.Store // opened by openHistory
+	genrePolicy   genre.Policy
+	nameFormats   struct {
+		Artist, Album, Playlist, Song *naming.Template
+		Disc                          *naming.Template // nil unless disc-folder-format is set
+	}
+	// urlArtist is the artist whose page the download started from, if any.
+	urlArtist naming.Artist
+	// syncVacated holds the path keys of files syncPlaylist moves away once
+	// the playlist has been downloaded.
+	syncVacated map[string]bool
 )
 
 func loadConfig() error {
@@ -61,17 +87,525 @@ func loadConfig() error {
 	if err != nil {
 		return err
 	}
+	return setupConfig()
+}
+
+// setupConfig checks Config and prepares what is derived from it. It is
+// shared by the command line and the web server.
+func setupConfig() error {
 	if len(Config.Storefront) != 2 {
 		Config.Storefront = "us"
 	}
+	if err := compileFormats(); err != nil {
+		return err
+	}
+	if err := setupPathPolicy(); err != nil {
+		return err
+	}
+	switch Config.PlaylistMode {
+	case "", "folder", "library":
+	default:
+		return fmt.Errorf("unknown playlist-mode %q (want folder or library)", Config.PlaylistMode)
+	}
+	for _, f := range Config.PlaylistFormats {
+		if !playlistfile.Valid(f) {
+			return fmt.Errorf("unknown playlist-formats entry %q (want %s)", f, strings.Join(playlistfile.Formats, ", "))
+		}
+	}
+	switch Config.SecondaryLanguageTags {
+	case "", "sort", "display", "custom":
+	default:
+		return fmt.Errorf("unknown secondary-language-tags %q (want sort, display or custom)", Config.SecondaryLanguageTags)
+	}
+	if err := setupLyricsFormats(); err != nil {
+		return err
+	}
+	genrePolicy = genre.Policy{
+		Mode:      Config.GenreMode,
+		Separator: Config.GenreSeparator,
+		Map:       Config.GenreMap,
+		Blacklist: Config.GenreBlacklist,
+		Fallback:  Config.GenreFallback,
+	}
+	if err := genrePolicy.Validate(); err != nil {
+		return err
+	}
+	setupTools()
+	return nil
+}
+
+// compileFormats parses the naming templates so mistakes surface at startup.
+func compileFormats() error {
+	opts := naming.Options{LimitMax: Config.LimitMax}
+	var err error
+	if nameFormats.Artist, err = naming.Parse("artist-folder-format", Config.ArtistFolderFormat, opts); err != nil {
+		return err
+	}
+	if nameFormats.Album, err = naming.Parse("album-folder-format", Config.AlbumFolderFormat, opts); err != nil {
+		return err
+	}
+	if nameFormats.Playlist, err = naming.Parse("playlist-folder-format", Config.PlaylistFolderFormat, opts); err != nil {
+		return err
+	}
+	if nameFormats.Song, err = naming.Parse("song-file-format", Config.SongFileFormat, opts); err != nil {
+		return err
+	}
+	nameFormats.Disc = nil
+	if Config.DiscFolderFormat != "" {
+		if nameFormats.Disc, err = naming.Parse("disc-folder-format", Config.DiscFolderFormat, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// useDiscFolders reports whether the track of a multi-disc album goes into
+// a per-disc subfolder.
+func useDiscFolders(track *task.Track) bool {
+	return nameFormats.Disc != nil && track.PreType == "albums" && track.DiscTotal > 1
+}
+
+// trackPosition is the number used for {SongNumer}. Album tracks use their
+// track number when disc folders or disc-aware numbering are enabled, with
+// the disc number prefixed when all discs share a folder.
+func trackPosition(track *task.Track) string {
+	attrs := track.Resp.Attributes
+	if track.PreType != "albums" || track.DiscTotal <= 1 {
+		return fmt.Sprintf("%02d", track.TaskNum)
+	}
+	if useDiscFolders(track) {
+		return fmt.Sprintf("%02d", attrs.TrackNumber)
+	}
+	if Config.DiscAwareNumbering {
+		return fmt.Sprintf("%d-%02d", attrs.DiscNumber, attrs.TrackNumber)
+	}
+	return fmt.Sprintf("%02d", track.TaskNum)
+}
+
+// albumNameData converts album attributes for the naming templates.
+func albumNameData(id string, album ampapi.AlbumRespData) naming.Album {
+	a := naming.Album{
+		ID:            id,
+		Name:          album.Attributes.Name,
+		ArtistName:    album.Attributes.ArtistName,
+		ReleaseDate:   album.Attributes.ReleaseDate,
+		UPC:           album.Attributes.Upc,
+		RecordLabel:   album.Attributes.RecordLabel,
+		Copyright:     album.Attributes.Copyright,
+		ContentRating: album.Attributes.ContentRating,
+		TrackCount:    album.Attributes.TrackCount,
+		IsCompilation: album.Attributes.IsCompilation,
+		IsSingle:      album.Attributes.IsSingle,
+	}
+	a.SecondaryName, a.SecondaryArtistName = a.Name, a.ArtistName
+	a.Genre = genrePolicy.Genre(album.Attributes.GenreNames)
+	if n := len(album.Relationships.Tracks.Data); n > 0 {
+		a.DiscTotal = album.Relationships.Tracks.Data[n-1].Attributes.DiscNumber
+	}
+	return a
+}
+
+// trackNameData collects everything the naming templates know about a track.
+func trackNameData(track *task.Track) naming.Data {
+	attrs := track.Resp.Attributes
+	d := naming.Data{
+		Artist: naming.Artist{Name: attrs.ArtistName, URLName: attrs.ArtistName},
+		Album:  albumNameData(track.AlbumData.ID, track.AlbumData),
+		Track: naming.Track{
+			ID:            track.ID,
+			Name:          attrs.Name,
+			ArtistName:    attrs.ArtistName,
+			AlbumName:     attrs.AlbumName,
+			ComposerName:  attrs.ComposerName,
+			WorkName:      attrs.WorkName,
+			MovementName:  attrs.MovementName,
+			MovementNum:   attrs.MovementNumber,
+			MovementTotal: attrs.MovementCount,
+			Attribution:   attrs.Attribution,
+			ISRC:          attrs.Isrc,
+			ReleaseDate:   attrs.ReleaseDate,
+			ContentRating: attrs.ContentRating,
+			Position:      trackPosition(track),
+			TaskNum:       track.TaskNum,
+			TaskTotal:     track.TaskTotal,
+			TrackNumber:   attrs.TrackNumber,
+			DiscNumber:    attrs.DiscNumber,
+			DiscTotal:     track.DiscTotal,
+			DurationMs:    attrs.DurationInMillis,
+		},
+		Quality: track.Quality,
+		Codec:   track.Codec,
+	}
+	d.Track.Genre = genrePolicy.Genre(attrs.GenreNames)
+	d.Artist.SecondaryName = cmp.Or(track.Secondary.ArtistName, attrs.ArtistName)
+	d.Track.SecondaryName = cmp.Or(track.Secondary.Name, attrs.Name)
+	d.Track.SecondaryArtistName = d.Artist.SecondaryName
+	d.Track.SecondaryAlbumName = cmp.Or(track.Secondary.AlbumName, attrs.AlbumName)
+	d.Album.SecondaryName = cmp.Or(track.Secondary.AlbumName, d.Album.Name)
+	d.Album.SecondaryArtistName = cmp.Or(track.Secondary.AlbumArtistName, d.Album.ArtistName)
+	if len(track.Resp.Relationships.Artists.Data) > 0 {
+		d.Artist.ID = track.Resp.Relationships.Artists.Data[0].ID
+	}
+	if track.PreType == "playlists" || track.PreType == "stations" {
+		d.Playlist = naming.Playlist{
+			ID:          track.PreID,
+			Name:        track.PlaylistData.Attributes.Name,
+			CuratorName: track.PlaylistData.Attributes.ArtistName,
+			TrackCount:  track.TaskTotal,
+		}
+	}
+	return d
+}
+
+// trackTag joins the configured markers for mastering and content rating.
+func trackTag(track *task.Track) string {
+	stringsToJoin := []string{}
+	if track.Resp.Attributes.IsAppleDigitalMaster {
+		if Config.AppleMasterChoice != "" {
+			stringsToJoin = append(stringsToJoin, Config.AppleMasterChoice)
+		}
+	}
+	if track.Resp.Attributes.ContentRating == "explicit" {
+		if Config.ExplicitChoice != "" {
+			stringsToJoin = append(stringsToJoin, Config.ExplicitChoice)
+		}
+	}
+	if track.Resp.Attributes.ContentRating == "clean" {
+		if Config.CleanChoice != "" {
+			stringsToJoin = append(stringsToJoin, Config.CleanChoice)
+		}
+	}
+	return strings.Join(stringsToJoin, " ")
+}
+
+// trackSongName renders song-file-format for the track, including any
+// suffix added to resolve a collision.
+func trackSongName(track *task.Track) string {
+	nameData := trackNameData(track)
+	nameData.Tag = trackTag(track)
+	songName := nameFormats.Song.ExecuteOr(nameData, nameData.Track.ID)
+	if track.NameSuffix != "" {
+		songName = fmt.Sprintf("%s (%s)", songName, track.NameSuffix)
+	}
+	return songName
+}
+
+// trackFilePath is where the track's .m4a is saved.
+func trackFilePath(track *task.Track) string {
+	return safepath.Join(track.SaveDir, safepath.File(trackSongName(track), ".m4a"))
+}
+
+// planTrackPaths precomputes the file path of every track in a job and
+// resolves tracks that would be saved to the same file according to
+// collision-strategy. The first track keeps its name. Quality is not known
+// before a track's manifest is fetched, so formats using it are compared
+// without it, which can only report more collisions, never fewer.
+func planTrackPaths(jobID string, tracks []task.Track) error {
+	groups := make(map[string][]int)
+	var keys []string
+	for i := range tracks {
+		if tracks[i].Type == "music-videos" {
+			continue
+		}
+		tracks[i].NameSuffix = ""
+		key := safepath.Default.Key(trackFilePath(&tracks[i]))
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+	taken := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		taken[key] = true
+	}
+	var failed []string
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+		first := trackFilePath(&tracks[group[0]])
+		if Config.CollisionStrategy == "fail" {
+			for _, i := range group[1:] {
+				jobReport.Add(report.Entry{
+					Kind:    report.Collision,
+					Job:     jobID,
+					TrackID: tracks[i].ID,
+					Path:    first,
+					Message: fmt.Sprintf("track %d %q has the same path as track %d", tracks[i].TaskNum, tracks[i].Resp.Attributes.Name, tracks[group[0]].TaskNum),
+				})
+			}
+			failed = append(failed, first)
+			continue
+		}
+		byArtist := Config.CollisionStrategy == "artist"
+		seen := map[string]bool{strings.ToLower(tracks[group[0]].Resp.Attributes.ArtistName): true}
+		for _, i := range group[1:] {
+			artist := strings.ToLower(tracks[i].Resp.Attributes.ArtistName)
+			if seen[artist] {
+				byArtist = false
+			}
+			seen[artist] = true
+		}
+		for _, i := range group[1:] {
+			suffix := tracks[i].ID
+			if byArtist {
+				suffix = tracks[i].Resp.Attributes.ArtistName
+			}
+			// the renamed path may be planned for another track as well
+			tracks[i].NameSuffix = suffix
+			path := trackFilePath(&tracks[i])
+			for n := 2; taken[safepath.Default.Key(path)] && n <= len(tracks)+1; n++ {
+				tracks[i].NameSuffix = fmt.Sprintf("%s %d", suffix, n)
+				path = trackFilePath(&tracks[i])
+			}
+			if taken[safepath.Default.Key(path)] {
+				// the suffix was cut off to fit the length limits
+				jobReport.Add(report.Entry{
+					Kind:    report.Collision,
+					Job:     jobID,
+					TrackID: tracks[i].ID,
+					Path:    path,
+					Message: fmt.Sprintf("track %d %q has the same path as track %d and no free name was found", tracks[i].TaskNum, tracks[i].Resp.Attributes.Name, tracks[group[0]].TaskNum),
+				})
+				failed = append(failed, path)
+				continue
+			}
+			taken[safepath.Default.Key(path)] = true
+			fmt.Printf("Name collision: track %d saved as %s\n", tracks[i].TaskNum, filepath.Base(path))
+			jobReport.Add(report.Entry{
+				Kind:    report.Collision,
+				Job:     jobID,
+				TrackID: tracks[i].ID,
+				Path:    path,
+				Message: fmt.Sprintf("renamed, same path as track %d: %s", tracks[group[0]].TaskNum, first),
+			})
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d unresolved file name collision(s), first at %s (collision-strategy: %s)", len(failed), failed[0], cmp.Or(Config.CollisionStrategy, "id"))
+	}
+	return nil
+}
+
+// setupLyricsFormats checks lrc-format, which defaults to lrc. Formats
+// must not share a file extension.
+func setupLyricsFormats() error {
+	if len(Config.LrcFormat) == 0 {
+		Config.LrcFormat = structs.StringList{"lrc"}
+	}
+	exts := make(map[string]string)
+	for _, format := range Config.LrcFormat {
+		w, ok := lyrics.Lookup(format)
+		if !ok {
+			return fmt.Errorf("unknown lrc-format %q (want %s)", format, strings.Join(lyrics.Formats(), ", "))
+		}
+		if other, ok := exts[w.Ext()]; ok {
+			return fmt.Errorf("lrc-format: %s and %s both save %s files", other, format, w.Ext())
+		}
+		exts[w.Ext()] = format
+	}
+	for _, layer := range Config.LrcLayers {
+		if !lyrics.IsLayer(layer) {
+			return fmt.Errorf("unknown lrc-layers entry %q (want original, translation or transliteration)", layer)
+		}
+	}
+	switch Config.LrcLayerMode {
+	case "", "interleave", "separate":
+	default:
+		return fmt.Errorf("unknown lrc-layer-mode %q (want interleave or separate)", Config.LrcLayerMode)
+	}
+	switch Config.LrcValidation {
+	case "", "report", "skip", "off":
+	default:
+		return fmt.Errorf("unknown lrc-validation %q (want report, skip or off)", Config.LrcValidation)
+	}
+	if len(Config.LyricsProviders) == 0 {
+		Config.LyricsProviders = structs.StringList{"apple"}
+	}
+	for _, name := range Config.LyricsProviders {
+		switch name {
+		case "apple", "local":
+		case "http":
+			if Config.LyricsURL == "" {
+				return errors.New("lyrics-providers: http needs lyrics-url")
+			}
+		default:
+			return fmt.Errorf("unknown lyrics-providers entry %q (want apple, local or http)", name)
+		}
+	}
+	return nil
+}
+
+// lyricsProviders returns the chain of lyrics-providers.
+func lyricsProviders(token, mediaUserToken string) lyrics.Chain {
+	var chain lyrics.Chain
+	for _, name := range Config.LyricsProviders {
+		switch name {
+		case "apple":
+			chain = append(chain, lyrics.Apple{
+				Token:          token,
+				MediaUserToken: mediaUserToken,
+				Type:           Config.LrcType,
+				Language:       Config.Language,
+			})
+		case "local":
+			chain = append(chain, lyrics.Local{Dir: Config.LyricsFolder})
+		case "http":
+			chain = append(chain, lyrics.HTTP{Template: Config.LyricsURL})
+		}
+	}
+	return chain
+}
+
+// lyricsOffset returns the shift of the lyrics timings of track: its entry
+// in lrc-track-offsets, by song ID or ISRC, or else lrc-offset.
+func lyricsOffset(track *task.Track) time.Duration {
+	return lyrics.Offset(Config.LrcTrackOffsets, Config.LrcOffset, track.ID, track.Resp.Attributes.Isrc)
+}
+
+// checkLyrics validates the lyrics of track against its duration and adds
+// the problems found to the job report. It reports false when the lyrics
+// are not to be used (lrc-validation: skip).
+func checkLyrics(track *task.Track, l *lyrics.Lyrics) bool {
+	duration := time.Duration(track.Resp.Attributes.DurationInMillis) * time.Millisecond
+	msg, ok := l.Check(duration, Config.LrcValidation)
+	if msg == "" {
+		return ok
+	}
+	fmt.Println("\u26A0 Lyrics:", msg)
+	jobReport.Add(report.Entry{
+		Kind:    report.Lyrics,
+		Job:     track.PreID,
+		TrackID: track.ID,
+		Path:    filepath.Join(track.SaveDir, track.SaveName),
+		Message: msg,
+	})
+	return ok
+}
+
+// lyricsQuery describes track to the lyrics providers.
+func lyricsQuery(track *task.Track) lyrics.Query {
+	attrs := track.Resp.Attributes
+	q := lyrics.Query{
+		Storefront: track.Storefront,
+		SongID:     track.ID,
+		ISRC:       attrs.Isrc,
+		Title:      attrs.Name,
+		Artist:     attrs.ArtistName,
+		Album:      attrs.AlbumName,
+		DurationMs: attrs.DurationInMillis,
+	}
+	if track.SaveName != "" {
+		q.Path = filepath.Join(track.SaveDir, track.SaveName)
+	}
+	return q
+}
+
+// lyricsLayout returns the layers chosen by lrc-layers, lrc-layer-mode and
+// lrc-translation-language.
+func lyricsLayout() lyrics.Layout {
+	return lyrics.Layout{
+		Layers:   Config.LrcLayers,
+		Separate: Config.LrcLayerMode == "separate",
+		Language: Config.LrcTranslationLanguage,
+	}
+}
+
+// lyricsSidecars lists the extensions of the lyrics files saved next to
+// each track, including the ".translation.lrc" style extensions of the
+// layer files of a separate layout.
+func lyricsSidecars() []string {
+	var exts []string
+	layout := lyricsLayout()
+	for _, format := range Config.LrcFormat {
+		w, ok := lyrics.Lookup(format)
+		if !ok {
+			continue
+		}
+		exts = append(exts, w.Ext())
+		if layout.Separate && format != "ttml" && len(layout.Layers) > 1 {
+			for _, layer := range layout.Layers[1:] {
+				exts = append(exts, "."+layer+w.Ext())
+			}
+		}
+	}
+	return exts
+}
+
+// setupPathPolicy selects how names are made safe for the library's filesystem.
+func setupPathPolicy() error {
+	policy, err := safepath.Lookup(Config.PathPolicy)
+	if err != nil {
+		return err
+	}
+	if policy, err = policy.WithForm(safepath.Form(Config.PathUnicodeForm)); err != nil {
+		return err
+	}
+	safepath.Default = policy
+	switch Config.CollisionStrategy {
+	case "", "id", "artist", "fail":
+	default:
+		return fmt.Errorf("unknown collision-strategy %q (want id, artist or fail)", Config.CollisionStrategy)
+	}
 	return nil
 }
 
-func LimitString(s string) string {
-	if len([]rune(s)) > Config.LimitMax {
-		return string([]rune(s)[:Config.LimitMax])
+// printReport lists the events recorded during the run and saves them to
+// report-file when configured.
+func printReport() {
+	entries := jobReport.Entries()
+	if len(entries) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Kind", "Job", "Track", "Message"})
+		table.SetAutoWrapText(false)
+		for _, e := range entries {
+			table.Append([]string{e.Kind, e.Job, e.TrackID, e.Message})
+		}
+		table.Render()
+	}
+	if Config.ReportFile != "" {
+		if err := jobReport.WriteJSON(Config.ReportFile); err != nil {
+			fmt.Println("Failed to write report:", err)
+		}
+	}
+}
+
+// saveRoot is the configured save folder for the current download mode.
+func saveRoot() string {
+	if dl_atmos {
+		return Config.AtmosSaveFolder
+	}
+	if dl_aac {
+		return Config.AacSaveFolder
+	}
+	return Config.AlacSaveFolder
+}
+
+// setupTools discovers the external binaries and warns about unusable ones.
+func setupTools() {
+	if Config.FakeTools {
+		tools.Use(tools.NewFakeSet(&tools.Fake{}))
+		return
+	}
+	set := tools.Init(tools.Paths{
+		MP4Box:     Config.MP4BoxPath,
+		Mp4Decrypt: Config.Mp4DecryptPath,
+		FFmpeg:     Config.FFmpegPath,
+	})
+	// stderr, so that they cannot end up in the output of --search --json
+	for _, st := range set.Missing() {
+		fmt.Fprintf(os.Stderr, "\u26A0 %v\n", st.Err)
+	}
+}
+
+// runDoctor reports the state of every external tool and whether all are usable.
+func runDoctor() bool {
+	ok := tools.Default.Doctor(os.Stdout)
+	if !ok {
+		fmt.Println("Set mp4box-path, mp4decrypt-path or ffmpeg-path in config.yaml if a tool is installed outside PATH.")
 	}
-	return s
+	return ok
 }
 
 func isInArray(arr []int, target int) bool {
@@ -155,6 +689,28 @@ func checkUrlArtist(url string) (string, string) {
 		return matches[0][1], matches[0][2]
 	}
 }
+func checkUrlLabel(url string) (string, string) {
+	pat := regexp.MustCompile(`^(?:https:\/\/(?:beta\.music|music|classical\.music)\.apple\.com\/(\w{2})(?:\/label|\/label\/.+))\/(?:id)?(\d[^\D]+)(?:$|\?)`)
+	matches := pat.FindAllStringSubmatch(url, -1)
+
+	if matches == nil {
+		return "", ""
+	} else {
+		return matches[0][1], matches[0][2]
+	}
+}
+
+func checkUrlCurator(url string) (string, string) {
+	pat := regexp.MustCompile(`^(?:https:\/\/(?:beta\.music|music|classical\.music)\.apple\.com\/(\w{2})(?:\/curator|\/curator\/.+))\/(?:id)?(\d[^\D]+)(?:$|\?)`)
+	matches := pat.FindAllStringSubmatch(url, -1)
+
+	if matches == nil {
+		return "", ""
+	} else {
+		return matches[0][1], matches[0][2]
+	}
+}
+
 func getUrlSong(songUrl string, token string) (string, error) {
 	storefront, songId := checkUrlSong(songUrl)
 	manifest, err := ampapi.GetSongResp(storefront, songId, Config.Language, token)
@@ -196,13 +752,40 @@ func getUrlArtistName(artistUrl string, token string) (string, string, error) {
 
 func checkArtist(artistUrl string, token string, relationship string) ([]string, error) {
 	storefront, artistId := checkUrlArtist(artistUrl)
+	endpoint := fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/artists/%s/%s", storefront, artistId, relationship)
+	kind := "Album"
+	if relationship == "music-videos" {
+		kind = "MV"
+	}
+	return selectCatalogItems(endpoint, 100, kind, relationship, token)
+}
+
+// checkLabel lists the latest releases of a record label to pick from.
+func checkLabel(labelUrl string, token string) ([]string, error) {
+	storefront, labelId := checkUrlLabel(labelUrl)
+	endpoint := fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/record-labels/%s/view/latest-releases", storefront, labelId)
+	return selectCatalogItems(endpoint, 25, "Album", "releases", token)
+}
+
+// checkCurator lists the playlists of an Apple Music curator to pick from.
+func checkCurator(curatorUrl string, token string) ([]string, error) {
+	storefront, curatorId := checkUrlCurator(curatorUrl)
+	endpoint := fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/apple-curators/%s/playlists", storefront, curatorId)
+	return selectCatalogItems(endpoint, 25, "Playlist", "playlists", token)
+}
+
+// selectCatalogItems pages through a catalog list, such as the albums of
+// an artist, and returns the URLs of the items the user picks from it, or
+// of all of them with --all-album. kind names the items in the table
+// header and what in the prompt.
+func selectCatalogItems(endpoint string, pageSize int, kind, what, token string) ([]string, error) {
 	Num := 0
 	//id := 1
 	var args []string
 	var urls []string
 	var options [][]string
 	for {
-		req, err := http.NewRequest("GET", fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/artists/%s/%s?limit=100&offset=%d&l=%s", storefront, artistId, relationship, Num, Config.Language), nil)
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s?limit=%d&offset=%d&l=%s", endpoint, pageSize, Num, Config.Language), nil)
 		if err != nil {
 			return nil, err
 		}
@@ -225,12 +808,12 @@ func checkArtist(artistUrl string, token string, relationship string) ([]string,
 		for _, album := range obj.Data {
 			options = append(options, []string{album.Attributes.Name, album.Attributes.ReleaseDate, album.ID, album.Attributes.URL})
 		}
-		Num = Num + 100
+		Num = Num + pageSize
 		if len(obj.Next) == 0 {
 			break
 		}
 	}
-	sort.Slice(options, func(i, j int) bool {
+	sort.SliceStable(options, func(i, j int) bool {
 		// 将日期字符串解析为 time.Time 类型进行比较
 		dateI, _ := time.Parse("2006-01-02", options[i][1])
 		dateJ, _ := time.Parse("2006-01-02", options[j][1])
@@ -238,11 +821,7 @@ func checkArtist(artistUrl string, token string, relationship string) ([]string,
 	})
 
 	table := tablewriter.NewWriter(os.Stdout)
-	if relationship == "albums" {
-		table.SetHeader([]string{"", "Album Name", "Date", "Album ID"})
-	} else if relationship == "music-videos" {
-		table.SetHeader([]string{"", "MV Name", "Date", "MV ID"})
-	}
+	table.SetHeader([]string{"", kind + " Name", "Date", kind + " ID"})
 	table.SetRowLine(false)
 	table.SetHeaderColor(tablewriter.Colors{},
 		tablewriter.Colors{tablewriter.FgRedColor, tablewriter.Bold},
@@ -264,7 +843,7 @@ func checkArtist(artistUrl string, token string, relationship string) ([]string,
 		return urls, nil
 	}
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Println("Please select from the " + relationship + " options above (multiple options separated by commas, ranges supported, or type 'all' to select all)")
+	fmt.Println("Please select from the " + what + " options above (multiple options separated by commas, ranges supported, or type 'all' to select all)")
 	cyanColor := color.New(color.FgCyan)
 	cyanColor.Print("Enter your choice: ")
 	input, _ := reader.ReadString('\n')
@@ -397,13 +976,18 @@ func contains(slice []string, item string) bool {
 
 // START: New functions for search functionality
 
-// SearchResultItem is a unified struct to hold search results for display.
+// SearchResultItem is a unified struct to hold search results for display
+// and for search --json and /api/search.
 type SearchResultItem struct {
-	Type   string
-	Name   string
-	Detail string
-	URL    string
-	ID     string
+	Type        string   `json:"type"`
+	Name        string   `json:"name"`
+	Detail      string   `json:"-"`
+	URL         string   `json:"url"`
+	ID          string   `json:"id"`
+	Artist      string   `json:"artist,omitempty"`
+	Year        string   `json:"year,omitempty"`
+	TrackCount  int      `json:"trackCount,omitempty"`
+	AudioTraits []string `json:"audioTraits,omitempty"`
 }
 
 // QualityOption holds information about a downloadable quality.
@@ -432,9 +1016,13 @@ func setDlFlags(quality string) {
 
 // promptForQuality asks the user to select a download quality for the chosen media.
 func promptForQuality(item SearchResultItem, token string) (string, error) {
-	if item.Type == "Artist" {
+	switch item.Type {
+	case "Artist":
 		fmt.Println("Artist selected. Proceeding to list all albums/videos.")
 		return "default", nil
+	case "Music Video", "Station":
+		// music videos use mv-max and mv-audio-type; stations have one quality
+		return "default", nil
 	}
 
 	fmt.Printf("\nFetching available qualities for: %s\n", item.Name)
@@ -465,30 +1053,248 @@ func promptForQuality(item SearchResultItem, token string) (string, error) {
 	return qualities[selectedIndex].ID, nil
 }
 
+// searchTypes maps the --search types to the catalog types they search.
+// top searches the main types for the mixed "top results" view.
+var searchTypes = map[string]string{
+	"album":         "albums",
+	"song":          "songs",
+	"artist":        "artists",
+	"playlist":      "playlists",
+	"music-video":   "music-videos",
+	"station":       "stations",
+	"record-label":  "record-labels",
+	"apple-curator": "apple-curators",
+	"top":           "albums,songs,artists,playlists,music-videos,stations",
+}
+
+// searchTypeNames lists the --search types for messages.
+const searchTypeNames = "album, song, artist, playlist, music-video, station, record-label, apple-curator or top"
+
+// searchResults runs one page of a search. The top view has a single page.
+func searchResults(searchType, query, token string, limit, offset int) ([]SearchResultItem, bool, error) {
+	types := searchTypes[searchType]
+	if searchType == "top" {
+		resp, err := ampapi.SearchTop(Config.Storefront, query, types, Config.Language, token, limit)
+		if err != nil || resp.Results.Top == nil {
+			return nil, false, err
+		}
+		var items []SearchResultItem
+		for _, item := range resp.Results.Top.Data {
+			items = append(items, searchItem(item))
+		}
+		return items, false, nil
+	}
+	resp, err := ampapi.Search(Config.Storefront, query, types, Config.Language, token, limit, offset)
+	if err != nil {
+		return nil, false, err
+	}
+	r := resp.Results
+	var items []SearchResultItem
+	switch searchType {
+	case "album":
+		if r.Albums == nil {
+			return nil, false, nil
+		}
+		for _, item := range r.Albums.Data {
+			year := ""
+			if len(item.Attributes.ReleaseDate) >= 4 {
+				year = item.Attributes.ReleaseDate[:4]
+			}
+			trackInfo := fmt.Sprintf("%d tracks", item.Attributes.TrackCount)
+			detail := fmt.Sprintf("%s (%s, %s)", item.Attributes.ArtistName, year, trackInfo)
+			items = append(items, SearchResultItem{
+				Type: "Album", Name: item.Attributes.Name, Detail: detail, URL: item.Attributes.URL, ID: item.ID,
+				Artist: item.Attributes.ArtistName, Year: year, TrackCount: item.Attributes.TrackCount,
+				AudioTraits: item.Attributes.AudioTraits,
+			})
+		}
+		return items, r.Albums.Next != "", nil
+	case "song":
+		if r.Songs == nil {
+			return nil, false, nil
+		}
+		for _, item := range r.Songs.Data {
+			detail := fmt.Sprintf("%s (%s)", item.Attributes.ArtistName, item.Attributes.AlbumName)
+			year := ""
+			if len(item.Attributes.ReleaseDate) >= 4 {
+				year = item.Attributes.ReleaseDate[:4]
+			}
+			items = append(items, SearchResultItem{
+				Type: "Song", Name: item.Attributes.Name, Detail: detail, URL: item.Attributes.URL, ID: item.ID,
+				Artist: item.Attributes.ArtistName, Year: year, AudioTraits: item.Attributes.AudioTraits,
+			})
+		}
+		return items, r.Songs.Next != "", nil
+	case "artist":
+		if r.Artists == nil {
+			return nil, false, nil
+		}
+		for _, item := range r.Artists.Data {
+			detail := strings.Join(item.Attributes.GenreNames, ", ")
+			items = append(items, SearchResultItem{Type: "Artist", Name: item.Attributes.Name, Detail: detail, URL: item.Attributes.URL, ID: item.ID})
+		}
+		return items, r.Artists.Next != "", nil
+	}
+	results := map[string]*ampapi.ItemResults{
+		"playlist":      r.Playlists,
+		"music-video":   r.MusicVideos,
+		"station":       r.Stations,
+		"record-label":  r.RecordLabels,
+		"apple-curator": r.AppleCurators,
+	}[searchType]
+	if results == nil {
+		return nil, false, nil
+	}
+	for _, item := range results.Data {
+		items = append(items, searchItem(item))
+	}
+	return items, results.Next != "", nil
+}
+
+// searchItem describes a search result of any type.
+func searchItem(item ampapi.SearchItem) SearchResultItem {
+	a := item.Attributes
+	year := func(date string) string {
+		if len(date) >= 4 {
+			return date[:4]
+		}
+		return ""
+	}
+	res := SearchResultItem{
+		Name:        a.Name,
+		URL:         a.URL,
+		ID:          item.ID,
+		Artist:      cmp.Or(a.ArtistName, a.CuratorName),
+		Year:        year(cmp.Or(a.ReleaseDate, a.LastModifiedDate)),
+		TrackCount:  a.TrackCount,
+		AudioTraits: a.AudioTraits,
+	}
+	switch item.Type {
+	case "albums":
+		res.Type = "Album"
+		res.Detail = fmt.Sprintf("%s (%s, %d tracks)", a.ArtistName, year(a.ReleaseDate), a.TrackCount)
+	case "songs":
+		res.Type = "Song"
+		res.Detail = fmt.Sprintf("%s (%s)", a.ArtistName, a.AlbumName)
+	case "artists":
+		res.Type = "Artist"
+		res.Detail = strings.Join(a.GenreNames, ", ")
+	case "playlists":
+		res.Type = "Playlist"
+		res.Detail = a.CuratorName
+	case "music-videos":
+		res.Type = "Music Video"
+		res.Detail = fmt.Sprintf("%s (%s)", a.ArtistName, year(a.ReleaseDate))
+	case "stations":
+		res.Type = "Station"
+		if a.IsLive {
+			res.Detail = "live"
+		}
+	case "record-labels":
+		res.Type = "Record Label"
+	case "apple-curators":
+		res.Type = "Curator"
+	default:
+		res.Type = item.Type
+	}
+	return res
+}
+
+// Label is how the search picker lists the item, with its type when the
+// results mix types.
+func (item SearchResultItem) Label(mixed bool) string {
+	label := item.Name
+	switch {
+	case item.Detail == "":
+	case item.Type == "Artist" || item.Type == "Station":
+		label = fmt.Sprintf("%s (%s)", item.Name, item.Detail)
+	default:
+		label = fmt.Sprintf("%s - %s", item.Name, item.Detail)
+	}
+	if mixed {
+		label = fmt.Sprintf("[%s] %s", item.Type, label)
+	}
+	return label
+}
+
+// searchPick runs a search without prompts. With asJSON it prints the first
+// page of results as JSON and returns ""; otherwise it returns the URL of
+// result pick, counted from 1. The two do not mix, so that the download
+// output of a pick never follows the JSON on stdout. A picked artist, record
+// label or curator downloads everything it lists, as with --all-album.
+func searchPick(searchType string, queryParts []string, token string, asJSON bool, pick int) (string, error) {
+	query := strings.Join(queryParts, " ")
+	if _, ok := searchTypes[searchType]; !ok {
+		return "", fmt.Errorf("invalid search type: %s. Use %s", searchType, searchTypeNames)
+	}
+	if pick < 0 {
+		return "", fmt.Errorf("invalid --pick %d: results are counted from 1", pick)
+	}
+	if asJSON && pick > 0 {
+		return "", errors.New("--json cannot be used with --pick or --first")
+	}
+	const limit = 15
+	if asJSON {
+		items, _, err := searchResults(searchType, query, token, limit, 0)
+		if err != nil {
+			return "", fmt.Errorf("error fetching search results: %w", err)
+		}
+		if items == nil {
+			items = []SearchResultItem{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return "", enc.Encode(items)
+	}
+	if pick == 0 {
+		return "", nil
+	}
+	offset := 0
+	if searchType != "top" {
+		offset = (pick - 1) / limit * limit
+	}
+	items, _, err := searchResults(searchType, query, token, limit, offset)
+	if err != nil {
+		return "", fmt.Errorf("error fetching search results: %w", err)
+	}
+	if pick-offset > len(items) {
+		return "", fmt.Errorf("no result %d for %q", pick, query)
+	}
+	item := items[pick-offset-1]
+	switch item.Type {
+	case "Song":
+		dl_song = true
+	case "Artist", "Record Label", "Curator":
+		// take their whole catalog, as --all-album does, instead of prompting
+		artist_select = true
+	}
+	fmt.Printf("Selected %s: %s\n", item.Type, item.Label(false))
+	return item.URL, nil
+}
+
 // handleSearch manages the entire interactive search process.
 func handleSearch(searchType string, queryParts []string, token string) (string, error) {
 	query := strings.Join(queryParts, " ")
-	validTypes := map[string]bool{"album": true, "song": true, "artist": true}
-	if !validTypes[searchType] {
-		return "", fmt.Errorf("invalid search type: %s. Use 'album', 'song', or 'artist'", searchType)
+	if _, ok := searchTypes[searchType]; !ok {
+		return "", fmt.Errorf("invalid search type: %s. Use %s", searchType, searchTypeNames)
 	}
 
-	fmt.Printf("Searching for %ss: \"%s\" in storefront \"%s\"\n", searchType, query, Config.Storefront)
+	what := searchType + "s"
+	if searchType == "top" {
+		what = "top results"
+	}
+	fmt.Printf("Searching for %s: \"%s\" in storefront \"%s\"\n", what, query, Config.Storefront)
 
 	offset := 0
 	limit := 15 // Increased limit for better navigation
 
-	apiSearchType := searchType + "s"
-
 	for {
-		searchResp, err := ampapi.Search(Config.Storefront, query, apiSearchType, Config.Language, token, limit, offset)
+		items, hasNext, err := searchResults(searchType, query, token, limit, offset)
 		if err != nil {
 			return "", fmt.Errorf("error fetching search results: %w", err)
 		}
 
-		var items []SearchResultItem
 		var displayOptions []string
-		hasNext := false
 
 		// Special options for navigation
 		const prevPageOpt = "⬅️  Previous Page"
@@ -499,42 +1305,8 @@ func handleSearch(searchType string, queryParts []string, token string) (string,
 			displayOptions = append(displayOptions, prevPageOpt)
 		}
 
-		switch searchType {
-		case "album":
-			if searchResp.Results.Albums != nil {
-				for _, item := range searchResp.Results.Albums.Data {
-					year := ""
-					if len(item.Attributes.ReleaseDate) >= 4 {
-						year = item.Attributes.ReleaseDate[:4]
-					}
-					trackInfo := fmt.Sprintf("%d tracks", item.Attributes.TrackCount)
-					detail := fmt.Sprintf("%s (%s, %s)", item.Attributes.ArtistName, year, trackInfo)
-					displayOptions = append(displayOptions, fmt.Sprintf("%s - %s", item.Attributes.Name, detail))
-					items = append(items, SearchResultItem{Type: "Album", URL: item.Attributes.URL, ID: item.ID})
-				}
-				hasNext = searchResp.Results.Albums.Next != ""
-			}
-		case "song":
-			if searchResp.Results.Songs != nil {
-				for _, item := range searchResp.Results.Songs.Data {
-					detail := fmt.Sprintf("%s (%s)", item.Attributes.ArtistName, item.Attributes.AlbumName)
-					displayOptions = append(displayOptions, fmt.Sprintf("%s - %s", item.Attributes.Name, detail))
-					items = append(items, SearchResultItem{Type: "Song", URL: item.Attributes.URL, ID: item.ID})
-				}
-				hasNext = searchResp.Results.Songs.Next != ""
-			}
-		case "artist":
-			if searchResp.Results.Artists != nil {
-				for _, item := range searchResp.Results.Artists.Data {
-					detail := ""
-					if len(item.Attributes.GenreNames) > 0 {
-						detail = strings.Join(item.Attributes.GenreNames, ", ")
-					}
-					displayOptions = append(displayOptions, fmt.Sprintf("%s (%s)", item.Attributes.Name, detail))
-					items = append(items, SearchResultItem{Type: "Artist", URL: item.Attributes.URL, ID: item.ID})
-				}
-				hasNext = searchResp.Results.Artists.Next != ""
-			}
+		for _, item := range items {
+			displayOptions = append(displayOptions, item.Label(searchType == "top"))
 		}
 
 		if len(items) == 0 && offset == 0 {
@@ -619,8 +1391,8 @@ func ripTrack(track *task.Track, token string, mediaUserToken string) {
 			counter.Success++
 			return
 		}
-		if _, err := exec.LookPath("mp4decrypt"); err != nil {
-			fmt.Println("mp4decrypt is not found, skip MV dl")
+		if !tools.Default.Mp4Decrypt.Status().OK() {
+			fmt.Println("mp4decrypt is not usable, skip MV dl")
 			counter.Success++
 			return
 		}
@@ -662,7 +1434,7 @@ func ripTrack(track *task.Track, token string, mediaUserToken string) {
 		}
 	}
 	var Quality string
-	if strings.Contains(Config.SongFileFormat, "Quality") {
+	if nameFormats.Song.Uses("Quality") {
 		if dl_atmos {
 			Quality = fmt.Sprintf("%dKbps", Config.AtmosMax-2000)
 		} else if needDlAacLc {
@@ -678,136 +1450,1036 @@ func ripTrack(track *task.Track, token string, mediaUserToken string) {
 	}
 	track.Quality = Quality
 
-	stringsToJoin := []string{}
-	if track.Resp.Attributes.IsAppleDigitalMaster {
-		if Config.AppleMasterChoice != "" {
-			stringsToJoin = append(stringsToJoin, Config.AppleMasterChoice)
+	songName := trackSongName(track)
+	fmt.Println(songName)
+	trackPath := trackFilePath(track)
+	os.MkdirAll(track.SaveDir, os.ModePerm)
+
+	if track.PrevPath != "" && track.PrevPath != trackPath {
+		// renamed by syncPlaylist along with its lyrics files; trackPath may
+		// hold another track's old file
+		if prev, _ := fileExists(track.PrevPath); prev {
+			fmt.Println("Track already exists locally as", filepath.Base(track.PrevPath))
+			track.SavePath = trackPath
+			counter.Success++
+			okDict[track.PreID] = append(okDict[track.PreID], track.TaskNum)
+			return
 		}
 	}
-	if track.Resp.Attributes.ContentRating == "explicit" {
-		if Config.ExplicitChoice != "" {
-			stringsToJoin = append(stringsToJoin, Config.ExplicitChoice)
+	if key := safepath.Default.Key(trackPath); syncVacated[key] && key != safepath.Default.Key(track.PrevPath) {
+		// another track's old file, which syncPlaylist moves away after
+		// the download and then moves this one into its place
+		track.SyncPath = trackPath
+		trackPath = syncTempPath(trackPath)
+	}
+	track.SaveName = filepath.Base(trackPath)
+	lrc, _ := trackLyrics(track, token, mediaUserToken)
+
+	exists, err := fileExists(trackPath)
+	if err != nil {
+		fmt.Println("Failed to check if track exists.")
+	}
+	if exists {
+		fmt.Println("Track already exists locally.")
+		track.SavePath = trackPath
+		if track.SyncPath == "" {
+			recordChecksum(track, trackPath, false)
 		}
+		counter.Success++
+		okDict[track.PreID] = append(okDict[track.PreID], track.TaskNum)
+		return
 	}
-	if track.Resp.Attributes.ContentRating == "clean" {
-		if Config.CleanChoice != "" {
-			stringsToJoin = append(stringsToJoin, Config.CleanChoice)
+	if Config.SkipOwned {
+		if owned, ok := findOwned(track); ok {
+			fmt.Println("Track already in library:", owned.Path)
+			track.SavePath = owned.Path
+			counter.Success++
+			okDict[track.PreID] = append(okDict[track.PreID], track.TaskNum)
+			return
 		}
 	}
-	Tag_string := strings.Join(stringsToJoin, " ")
+	if needDlAacLc {
+		if len(mediaUserToken) <= 50 {
+			fmt.Println("Invalid media-user-token")
+			counter.Error++
+			return
+		}
+		_, err := runv3.Run(track.ID, trackPath, token, mediaUserToken, false)
+		if err != nil {
+			fmt.Println("Failed to dl aac-lc:", err)
+			if err.Error() == "Unavailable" {
+				counter.Unavailable++
+				return
+			}
+			counter.Error++
+			return
+		}
+	} else {
+		trackM3u8Url, _, err := extractMedia(track.M3u8, false)
+		if err != nil {
+			fmt.Println("\u26A0 Failed to extract info from manifest:", err)
+			counter.Unavailable++
+			return
+		}
+		//边下载边解密
+		err = runv2.Run(track.ID, trackM3u8Url, trackPath, Config)
+		if err != nil {
+			fmt.Println("Failed to run v2:", err)
+			counter.Error++
+			return
+		}
+	}
+	if Config.EmbedCover {
+		if (strings.Contains(track.PreID, "pl.") || strings.Contains(track.PreID, "ra.")) && Config.DlAlbumcoverForPlaylist {
+			track.CoverPath, err = writeCover(track.SaveDir, track.ID, track.Resp.Attributes.Artwork.URL)
+			if err != nil {
+				fmt.Println("Failed to write cover.")
+			}
+		}
+	}
+	if err := embedITags(track, trackPath); err != nil {
+		fmt.Printf("Embed failed: %v\n", err)
+		counter.Error++
+		return
+	}
+	if (strings.Contains(track.PreID, "pl.") || strings.Contains(track.PreID, "ra.")) && Config.DlAlbumcoverForPlaylist {
+		if err := os.Remove(track.CoverPath); err != nil {
+			fmt.Printf("Error deleting file: %s\n", track.CoverPath)
+			counter.Error++
+			return
+		}
+	}
+	track.SavePath = trackPath
+	err = writeMP4Tags(track, lrc)
+	if err != nil {
+		fmt.Println("\u26A0 Failed to write tags in media:", err)
+		counter.Unavailable++
+		return
+	}
+	if track.SyncPath == "" {
+		recordHistory(trackPath)
+		recordChecksum(track, trackPath, true)
+	}
+	counter.Success++
+	okDict[track.PreID] = append(okDict[track.PreID], track.TaskNum)
+}
 
-	songName := strings.NewReplacer(
-		"{SongId}", track.ID,
-		"{SongNumer}", fmt.Sprintf("%02d", track.TaskNum),
-		"{SongName}", LimitString(track.Resp.Attributes.Name),
-		"{DiscNumber}", fmt.Sprintf("%0d", track.Resp.Attributes.DiscNumber),
-		"{TrackNumber}", fmt.Sprintf("%0d", track.Resp.Attributes.TrackNumber),
-		"{Quality}", Quality,
-		"{Tag}", Tag_string,
-		"{Codec}", track.Codec,
-	).Replace(Config.SongFileFormat)
-	fmt.Println(songName)
-	filename := fmt.Sprintf("%s.m4a", forbiddenNames.ReplaceAllString(songName, "_"))
-	track.SaveName = filename
-	trackPath := filepath.Join(track.SaveDir, track.SaveName)
-	lrcFilename := fmt.Sprintf("%s.%s", forbiddenNames.ReplaceAllString(songName, "_"), Config.LrcFormat)
-
-	//get lrc
-	var lrc string = ""
-	if Config.EmbedLrc || Config.SaveLrcFile {
-		lrcStr, err := lyrics.Get(track.Storefront, track.ID, Config.LrcType, Config.Language, Config.LrcFormat, token, mediaUserToken)
+// syncTempPath is the name a synced playlist track is downloaded under
+// while path still holds another track's old file.
+func syncTempPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".sync-new" + filepath.Ext(path)
+}
+
+// savesOverLyrics reports whether trackLyrics saves over the file l was
+// read from. That file was shifted when an earlier run saved it, so
+// shifting it again would add the offset once more on every run.
+func savesOverLyrics(track *task.Track, l *lyrics.Lyrics) bool {
+	if l.File == "" || !Config.SaveLrcFile {
+		return false
+	}
+	base := filepath.Join(track.SaveDir, strings.TrimSuffix(track.SaveName, ".m4a"))
+	for _, format := range Config.LrcFormat {
+		if w, ok := lyrics.Lookup(format); ok && filepath.Clean(l.File) == base+w.Ext() {
+			return true
+		}
+	}
+	return false
+}
+
+// trackLyrics fetches the lyrics of a track, saving them next to
+// track.SaveName in every lrc-format when save-lrc-file is set, and returns
+// them in the first format if they are to be embedded. With a separate
+// layout the first layer is embedded and the others go to their own files.
+// found reports whether any lyrics passed lrc-validation.
+func trackLyrics(track *task.Track, token, mediaUserToken string) (embed string, found bool) {
+	if !Config.EmbedLrc && !Config.SaveLrcFile {
+		return "", false
+	}
+	l, err := lyricsProviders(token, mediaUserToken).Fetch(lyricsQuery(track))
+	if err != nil {
+		fmt.Println(err)
+		return "", false
+	}
+	if !savesOverLyrics(track, l) {
+		l.Shift(lyricsOffset(track))
+	}
+	if !checkLyrics(track, l) {
+		return "", false
+	}
+	layout := lyricsLayout()
+	for i, format := range Config.LrcFormat {
+		files, err := l.Render(format, layout)
 		if err != nil {
-			fmt.Println(err)
-		} else {
-			if Config.SaveLrcFile {
-				err := writeLyrics(track.SaveDir, lrcFilename, lrcStr)
-				if err != nil {
-					fmt.Printf("Failed to write lyrics")
+			fmt.Printf("Failed to convert lyrics to %s: %v\n", format, err)
+			continue
+		}
+		w, _ := lyrics.Lookup(format)
+		base := strings.TrimSuffix(track.SaveName, ".m4a")
+		for _, f := range files {
+			if i == 0 && f.Suffix == "" {
+				embed = f.Data
+			}
+			if !Config.SaveLrcFile {
+				continue
+			}
+			lrcFilename := base + w.Ext()
+			if f.Suffix != "" {
+				lrcFilename = base + "." + f.Suffix + w.Ext()
+			}
+			if err := writeLyrics(track.SaveDir, lrcFilename, f.Data); err != nil {
+				fmt.Println("Failed to write lyrics:", err)
+			}
+		}
+	}
+	if !Config.EmbedLrc {
+		return "", true
+	}
+	return embed, true
+}
+
+// embedITags writes the tags MP4Box handles: it clears the encoder tool and
+// embeds track.CoverPath when embed-cover is set.
+func embedITags(track *task.Track, path string) error {
+	tags := []string{
+		"tool=",
+		fmt.Sprintf("artist=%s", track.Resp.Attributes.ArtistName),
+	}
+	if Config.EmbedCover {
+		tags = append(tags, fmt.Sprintf("cover=%s", track.CoverPath))
+	}
+	return tools.Default.MP4Box.ITags(path, tags)
+}
+
+// checksumFolder is the folder whose manifest lists the file of a track:
+// the folder of an existing manifest or, with checksum-manifest set, the
+// album folder above any disc folder. It is empty when there is none.
+func checksumFolder(track *task.Track, path string) string {
+	if dir := manifest.Find(path); dir != "" {
+		return dir
+	}
+	if !Config.ChecksumManifest {
+		return ""
+	}
+	dir := filepath.Dir(path)
+	if useDiscFolders(track) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// recordChecksum adds the file of a track to its folder's checksum
+// manifest. A file already listed is only hashed again when replace is set,
+// so that re-running a download cannot hide corruption.
+func recordChecksum(track *task.Track, path string, replace bool) {
+	dir := checksumFolder(track, path)
+	if dir == "" {
+		return
+	}
+	m, err := manifest.Load(dir)
+	if err != nil {
+		fmt.Println("Failed to read checksums:", err)
+		return
+	}
+	if !replace && m.Has(dir, path) {
+		return
+	}
+	e, err := manifest.Hash(dir, path)
+	if err != nil {
+		fmt.Println("Failed to hash file:", err)
+		return
+	}
+	e.AdamID = track.ID
+	e.Codec = track.Codec
+	if q, err := library.ReadQuality(path); err == nil {
+		e.Codec = q.String()
+	}
+	if m.ID == "" {
+		m.ID = track.PreID
+	}
+	m.Put(e)
+	if err := m.Save(dir); err != nil {
+		fmt.Println("Failed to write checksums:", err)
+	}
+}
+
+// forgetChecksum drops a file that was moved away from its manifest.
+func forgetChecksum(path string) {
+	dir := manifest.Find(path)
+	if dir == "" {
+		return
+	}
+	m, err := manifest.Load(dir)
+	if err != nil || !m.Has(dir, path) {
+		return
+	}
+	m.Remove(dir, path)
+	if err := m.Save(dir); err != nil {
+		fmt.Println("Failed to write checksums:", err)
+	}
+}
+
+// runVerify checks every checksum manifest below the roots and returns the
+// number of problems found, which are also added to the job report.
+func runVerify(roots []string) (int, error) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Problem", "File", "Detail"})
+	problems, checked := 0, 0
+	for _, root := range roots {
+		dirs, err := manifest.Folders(root)
+		if err != nil {
+			return problems, err
+		}
+		if len(dirs) == 0 {
+			fmt.Println("No checksum manifests found in", root)
+		}
+		for _, dir := range dirs {
+			found, n, err := manifest.Verify(dir, ".m4a", ".mp4")
+			if err != nil {
+				return problems, err
+			}
+			checked += n
+			for _, p := range found {
+				problems++
+				table.Append([]string{p.Kind, p.Path, p.Detail})
+				jobReport.Add(report.Entry{
+					Kind:    report.Verify,
+					Job:     dir,
+					Path:    p.Path,
+					Message: p.Kind + ": " + p.Detail,
+				})
+			}
+		}
+	}
+	fmt.Printf("%d files checked, %d problems\n", checked, problems)
+	if problems > 0 {
+		table.Render()
+	}
+	return problems, nil
+}
+
+// openHistory opens the history store on first use.
+func openHistory() (*history.Store, error) {
+	if historyStore != nil {
+		return historyStore, nil
+	}
+	path := Config.HistoryFile
+	if path == "" {
+		path = "history.json"
+	}
+	store, err := history.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("history store %s: %w", path, err)
+	}
+	historyStore = store
+	return store, nil
+}
+
+// saveHistory writes the history store if it was used and changed.
+func saveHistory() {
+	if historyStore == nil {
+		return
+	}
+	if err := historyStore.Save(); err != nil {
+		fmt.Println("Failed to save history store:", err)
+	}
+}
+
+// recordHistory indexes a freshly downloaded file from its tags.
+func recordHistory(path string) {
+	store, err := openHistory()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	t, err := library.ReadTrack(abs)
+	if err != nil {
+		return
+	}
+	if info, err := os.Stat(abs); err == nil {
+		t.Size = info.Size()
+		t.ModTime = info.ModTime()
+	}
+	store.Put(t)
+}
+
+// findOwned looks the track up in the history store by ISRC, or by album
+// ID, disc and track number for album tracks. Entries whose file has gone
+// are ignored.
+func findOwned(track *task.Track) (history.Track, bool) {
+	store, err := openHistory()
+	if err != nil {
+		fmt.Println(err)
+		return history.Track{}, false
+	}
+	var albumID int64
+	if track.PreType == "albums" {
+		albumID, _ = strconv.ParseInt(track.PreID, 10, 64)
+	}
+	attrs := track.Resp.Attributes
+	owned, ok := store.Find(attrs.Isrc, albumID, attrs.DiscNumber, attrs.TrackNumber)
+	if !ok {
+		return owned, false
+	}
+	if exists, _ := fileExists(owned.Path); !exists {
+		return owned, false
+	}
+	return owned, true
+}
+
+// runLibraryScan indexes the .m4a files below each root into the history
+// store.
+func runLibraryScan(roots []string) error {
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		fmt.Println("Scanning", root)
+		res, err := library.Scan(root, store, func(path string, err error) {
+			fmt.Printf("\u26A0 %s: %v\n", path, err)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d files: %d indexed, %d unchanged, %d failed, %d removed from index\n",
+			res.Scanned, res.Updated, res.Unchanged, res.Failed, res.Removed)
+	}
+	if err := store.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("History store %s: %d tracks\n", store.Path(), store.Len())
+	return nil
+}
+
+// runLibraryMissing lists the tracks of an album that are not in the
+// history store and returns how many are missing.
+func runLibraryMissing(albumId, storefront, token string) (int, error) {
+	store, err := openHistory()
+	if err != nil {
+		return 0, err
+	}
+	resp, err := ampapi.GetAlbumResp(storefront, albumId, Config.Language, token)
+	if err != nil {
+		return 0, err
+	}
+	album := resp.Data[0]
+	id, _ := strconv.ParseInt(albumId, 10, 64)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Disc", "Track", "Name", "ISRC"})
+	missing := 0
+	for _, t := range album.Relationships.Tracks.Data {
+		if t.Type == "music-videos" {
+			continue
+		}
+		owned, ok := store.Find(t.Attributes.Isrc, id, t.Attributes.DiscNumber, t.Attributes.TrackNumber)
+		if ok {
+			if exists, _ := fileExists(owned.Path); exists {
+				continue
+			}
+		}
+		missing++
+		table.Append([]string{strconv.Itoa(t.Attributes.DiscNumber), strconv.Itoa(t.Attributes.TrackNumber), t.Attributes.Name, t.Attributes.Isrc})
+	}
+	fmt.Printf("%s - %s: %d of %d tracks missing\n", album.Attributes.ArtistName, album.Attributes.Name, missing, len(album.Relationships.Tracks.Data))
+	if missing > 0 {
+		table.Render()
+	}
+	return missing, nil
+}
+
+// manifestQuality parses the quality extractMedia reports for the current
+// download mode.
+func manifestQuality(quality string) library.Quality {
+	switch {
+	case dl_atmos:
+		return library.ParseManifestQuality("ec-3", quality)
+	case dl_aac:
+		return library.ParseManifestQuality("mp4a", quality)
+	}
+	return library.ParseManifestQuality("alac", quality)
+}
+
+// runUpgrade re-downloads the library tracks for which the catalog now
+// offers a better stream than the stored file in the current download mode.
+// With roots, those folders are scanned first and only their tracks are
+// checked; otherwise every album track in the history store is.
+func runUpgrade(roots []string, token string) error {
+	if dl_aac && Config.AacType == "aac-lc" {
+		return errors.New("aac-lc has a single quality, nothing to upgrade to")
+	}
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	var tracks []history.Track
+	if len(roots) > 0 {
+		if err := runLibraryScan(roots); err != nil {
+			return err
+		}
+		for _, root := range roots {
+			abs, err := filepath.Abs(root)
+			if err != nil {
+				return err
+			}
+			for _, path := range store.Under(abs) {
+				if t, ok := store.Get(path); ok {
+					tracks = append(tracks, t)
+				}
+			}
+		}
+	} else {
+		tracks = store.Tracks()
+	}
+	albums := make(map[int64][]history.Track)
+	var order []int64
+	skipped := 0
+	for _, t := range tracks {
+		if t.AlbumID == 0 || t.TrackNumber == 0 {
+			skipped++
+			continue
+		}
+		if _, ok := albums[t.AlbumID]; !ok {
+			order = append(order, t.AlbumID)
+		}
+		albums[t.AlbumID] = append(albums[t.AlbumID], t)
+	}
+	if skipped > 0 {
+		fmt.Printf("Skipping %d tracks without album ID tags\n", skipped)
+	}
+	for _, id := range order {
+		upgradeAlbum(strconv.FormatInt(id, 10), albums[id], token)
+	}
+	return store.Save()
+}
+
+// upgradeAlbum checks the owned tracks of one album against its catalog
+// entry. Tracks are matched by ISRC, then by disc and track number.
+func upgradeAlbum(albumId string, owned []history.Track, token string) {
+	album := task.NewAlbum(Config.Storefront, albumId)
+	if err := album.GetResp(token, Config.Language); err != nil {
+		fmt.Printf("\u26A0 Album %s: %v\n", albumId, err)
+		counter.Total += len(owned)
+		counter.Error += len(owned)
+		return
+	}
+	fmt.Printf("%s - %s\n", album.Resp.Data[0].Attributes.ArtistName, album.Name)
+	for _, t := range owned {
+		match := matchAlbumTrack(album, t)
+		counter.Total++
+		if match == nil || match.Type == "music-videos" {
+			fmt.Println("Not found in the catalog album:", t.Path)
+			counter.Unavailable++
+			continue
+		}
+		upgradeTrack(albumId, match, t.Path)
+	}
+}
+
+// matchAlbumTrack finds the catalog track of a library file by ISRC, then
+// by disc and track number.
+func matchAlbumTrack(album *task.Album, t history.Track) *task.Track {
+	var match *task.Track
+	for i := range album.Tracks {
+		attrs := album.Tracks[i].Resp.Attributes
+		if t.ISRC != "" && strings.EqualFold(attrs.Isrc, t.ISRC) {
+			return &album.Tracks[i]
+		}
+		if match == nil && attrs.DiscNumber == t.DiscNumber && attrs.TrackNumber == t.TrackNumber {
+			match = &album.Tracks[i]
+		}
+	}
+	return match
+}
+
+// upgradeTrack downloads the best variant of track next to path when it is
+// better than the file, copies the file's tags onto it and then replaces
+// the file in one rename.
+func upgradeTrack(albumId string, track *task.Track, path string) {
+	current, err := library.ReadQuality(path)
+	if err != nil {
+		fmt.Printf("\u26A0 %s: %v\n", path, err)
+		counter.Error++
+		return
+	}
+	if track.WebM3u8 == "" {
+		fmt.Printf("%s: %s, no enhanced stream available\n", track.Name, current)
+		counter.Success++
+		return
+	}
+	needCheck := false
+	if Config.GetM3u8Mode == "all" {
+		needCheck = true
+	} else if Config.GetM3u8Mode == "hires" && contains(track.Resp.Attributes.AudioTraits, "hi-res-lossless") {
+		needCheck = true
+	}
+	if needCheck {
+		if m3u8Url, _ := checkM3u8(track.ID, "song"); strings.HasSuffix(m3u8Url, ".m3u8") {
+			track.M3u8 = m3u8Url
+		}
+	}
+	streamUrl, Quality, err := extractMedia(track.M3u8, true)
+	if err != nil {
+		fmt.Printf("\u26A0 %s: %v\n", track.Name, err)
+		counter.Unavailable++
+		return
+	}
+	if streamUrl == "" {
+		// debug mode only lists the variants
+		counter.Success++
+		return
+	}
+	offered := manifestQuality(Quality)
+	if !offered.Better(current) {
+		fmt.Printf("%s: %s, up to date\n", track.Name, current)
+		counter.Success++
+		return
+	}
+	fmt.Printf("%s: %s -> %s\n", track.Name, current, offered)
+	tmp := strings.TrimSuffix(path, filepath.Ext(path)) + ".upgrade.m4a"
+	if err := runv2.Run(track.ID, streamUrl, tmp, Config); err != nil {
+		fmt.Println("Failed to run v2:", err)
+		os.Remove(tmp)
+		counter.Error++
+		return
+	}
+	err = library.CopyTags(path, tmp)
+	if err == nil {
+		err = writeITunesAtoms(track, tmp)
+	}
+	if err != nil {
+		fmt.Println("\u26A0 Failed to copy tags:", err)
+		os.Remove(tmp)
+		counter.Error++
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		fmt.Println("\u26A0 Failed to replace file:", err)
+		os.Remove(tmp)
+		counter.Error++
+		return
+	}
+	recordHistory(path)
+	recordChecksum(track, path, true)
+	jobReport.Add(report.Entry{
+		Kind:    report.Upgrade,
+		Job:     albumId,
+		TrackID: track.ID,
+		Path:    path,
+		Message: fmt.Sprintf("%s -> %s", current, offered),
+	})
+	counter.Success++
+}
+
+// qualityName formats a file's quality the way extractMedia reports it, for
+// the Quality naming field.
+func qualityName(q library.Quality) string {
+	if q.Codec == "alac" {
+		return fmt.Sprintf("%dB-%.1fkHz", q.BitDepth, float64(q.SampleRate)/1000)
+	}
+	return fmt.Sprintf("%d Kbps", q.Bitrate)
+}
+
+// runRetag rewrites the tags, lyrics and cover of library files from the
+// catalog without downloading the audio again. Each argument is a file, a
+// folder to walk, or an album URL whose tracks are looked up in the history
+// store. Files are matched to the catalog by the album ID in their tags,
+// falling back to the history store. With --move they are also renamed to
+// the current folder and file name formats.
+func runRetag(args []string, token, mediaUserToken string) error {
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	albums := make(map[int64][]history.Track)
+	storefronts := make(map[int64]string)
+	var order []int64
+	add := func(t history.Track, storefront string) {
+		if _, ok := albums[t.AlbumID]; !ok {
+			order = append(order, t.AlbumID)
+			storefronts[t.AlbumID] = storefront
+		}
+		albums[t.AlbumID] = append(albums[t.AlbumID], t)
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "http") {
+			storefront, albumId := checkUrl(arg)
+			id, err := strconv.ParseInt(albumId, 10, 64)
+			if err != nil {
+				fmt.Println("Invalid album URL:", arg)
+				continue
+			}
+			found := 0
+			for _, t := range store.Tracks() {
+				if t.AlbumID == id {
+					add(t, storefront)
+					found++
 				}
 			}
-			if Config.EmbedLrc {
-				lrc = lrcStr
+			if found == 0 {
+				fmt.Println("No tracks of this album in the history store:", arg)
 			}
+			continue
+		}
+		root, err := filepath.Abs(arg)
+		if err != nil {
+			return err
+		}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".m4a") {
+				return nil
+			}
+			t, err := library.ReadTrack(path)
+			if err != nil {
+				fmt.Printf("\u26A0 %s: %v\n", path, err)
+				return nil
+			}
+			if t.AlbumID == 0 {
+				if known, ok := store.Get(path); ok && known.AlbumID != 0 {
+					t = known
+				}
+			}
+			if t.AlbumID == 0 || t.TrackNumber == 0 {
+				fmt.Println("No album ID, skipping:", path)
+				return nil
+			}
+			add(t, Config.Storefront)
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	}
+	for _, id := range order {
+		retagAlbum(strconv.FormatInt(id, 10), storefronts[id], albums[id], token, mediaUserToken)
+	}
+	return store.Save()
+}
 
-	exists, err := fileExists(trackPath)
-	if err != nil {
-		fmt.Println("Failed to check if track exists.")
+// retagAlbum refreshes the files of one album.
+func retagAlbum(albumId, storefront string, files []history.Track, token, mediaUserToken string) {
+	album := task.NewAlbum(storefront, albumId)
+	if err := album.GetResp(token, Config.Language); err != nil {
+		fmt.Printf("\u26A0 Album %s: %v\n", albumId, err)
+		counter.Total += len(files)
+		counter.Error += len(files)
+		return
 	}
-	if exists {
-		fmt.Println("Track already exists locally.")
-		counter.Success++
-		okDict[track.PreID] = append(okDict[track.PreID], track.TaskNum)
+	loadSecondaryNames(album.Tracks, storefront, token)
+	meta := album.Resp.Data[0]
+	fmt.Printf("%s - %s\n", meta.Attributes.ArtistName, album.Name)
+	var layout albumLayout
+	if retag_move {
+		layout = planAlbumLayout(albumId, storefront, album.Language, token, meta)
+		for i := range album.Tracks {
+			album.Tracks[i].Codec = layout.Codec
+			album.Tracks[i].SaveDir = trackAlbumDir(&album.Tracks[i], layout)
+		}
+	}
+	paths := make(map[*task.Track]string)
+	for _, f := range files {
+		counter.Total++
+		track := matchAlbumTrack(album, f)
+		if track == nil || track.Type == "music-videos" {
+			fmt.Println("Not found in the catalog album:", f.Path)
+			counter.Unavailable++
+			continue
+		}
+		if retag_move {
+			if q, err := library.ReadQuality(f.Path); err == nil {
+				track.Quality = qualityName(q)
+			}
+		}
+		paths[track] = f.Path
+	}
+	if len(paths) == 0 {
 		return
 	}
-	if needDlAacLc {
-		if len(mediaUserToken) <= 50 {
-			fmt.Println("Invalid media-user-token")
-			counter.Error++
+	if retag_move {
+		if err := planTrackPaths(albumId, album.Tracks); err != nil {
+			fmt.Println(err)
+			counter.Error += len(paths)
 			return
 		}
-		_, err := runv3.Run(track.ID, trackPath, token, mediaUserToken, false)
+	}
+	var coverPath string
+	if Config.EmbedCover {
+		var err error
+		coverDir := layout.Dir
+		if coverDir == "" {
+			coverDir = os.TempDir()
+		}
+		name := "cover"
+		if !retag_move {
+			name = "cover-" + albumId
+		}
+		coverPath, err = writeCover(coverDir, name, meta.Attributes.Artwork.URL)
 		if err != nil {
-			fmt.Println("Failed to dl aac-lc:", err)
-			if err.Error() == "Unavailable" {
-				counter.Unavailable++
+			fmt.Println("Failed to write cover.")
+		} else if !retag_move {
+			defer os.Remove(coverPath)
+		}
+	}
+	if retag_move {
+		writeAlbumSidecars(layout, meta, coverPath, "")
+	}
+	for i := range album.Tracks {
+		track := &album.Tracks[i]
+		path, ok := paths[track]
+		if !ok {
+			continue
+		}
+		track.CoverPath = coverPath
+		retagTrack(track, path, token, mediaUserToken)
+	}
+}
+
+// retagTrack moves the file of track to its planned path when --move is
+// set, then rewrites its lyrics and tags.
+func retagTrack(track *task.Track, path, token, mediaUserToken string) {
+	fmt.Println(trackSongName(track))
+	if retag_move {
+		newPath := trackFilePath(track)
+		if newPath != path {
+			moved, err := moveTrackFile(path, newPath)
+			if err != nil {
+				fmt.Println("\u26A0 Failed to move file:", err)
+				counter.Error++
 				return
 			}
-			counter.Error++
-			return
+			if moved {
+				fmt.Printf("Moved to %s\n", newPath)
+				if historyStore != nil {
+					historyStore.Remove(path)
+				}
+				forgetChecksum(path)
+				path = newPath
+			}
 		}
-	} else {
-		trackM3u8Url, _, err := extractMedia(track.M3u8, false)
+	}
+	track.SaveDir = filepath.Dir(path)
+	track.SaveName = filepath.Base(path)
+	track.SavePath = path
+	lrc, _ := trackLyrics(track, token, mediaUserToken)
+	if err := embedITags(track, path); err != nil {
+		fmt.Printf("Embed failed: %v\n", err)
+		counter.Error++
+		return
+	}
+	if err := writeMP4Tags(track, lrc); err != nil {
+		fmt.Println("\u26A0 Failed to write tags in media:", err)
+		counter.Error++
+		return
+	}
+	recordHistory(path)
+	recordChecksum(track, path, true)
+	counter.Success++
+}
+
+// moveTrackFile renames a track and its lyrics file. It refuses to replace
+// another file and reports false when the target is taken. Folders left
+// empty below the save folder are removed.
+func moveTrackFile(from, to string) (bool, error) {
+	if exists, _ := fileExists(to); exists {
+		fmt.Println("Target already exists, not moving:", to)
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return false, err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return false, err
+	}
+	for _, ext := range lyricsSidecars() {
+		lrcFrom := strings.TrimSuffix(from, filepath.Ext(from)) + ext
+		if exists, _ := fileExists(lrcFrom); exists {
+			lrcTo := strings.TrimSuffix(to, filepath.Ext(to)) + ext
+			if err := os.Rename(lrcFrom, lrcTo); err != nil {
+				fmt.Println("Failed to move lyrics:", err)
+			}
+		}
+	}
+	root, _ := filepath.Abs(saveRoot())
+	for dir := filepath.Dir(from); strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return true, nil
+}
+
+// runLyricsFetch adds lyrics to the .m4a files below each path, embedding
+// them or saving them next to the file as configured. The catalog track is
+// found through the album ID in the file's tags or else its ISRC. Files that
+// already have lyrics are skipped unless lyrics_force is set.
+func runLyricsFetch(paths []string, token, mediaUserToken string) error {
+	if !Config.EmbedLrc && !Config.SaveLrcFile {
+		return errors.New("neither embed-lrc nor save-lrc-file is set")
+	}
+	albums := make(map[int64]*task.Album)
+	skipped := 0
+	for _, arg := range paths {
+		root, err := filepath.Abs(arg)
 		if err != nil {
-			fmt.Println("\u26A0 Failed to extract info from manifest:", err)
-			counter.Unavailable++
-			return
+			return err
 		}
-		//边下载边解密
-		err = runv2.Run(track.ID, trackM3u8Url, trackPath, Config)
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".m4a") {
+				return nil
+			}
+			if !lyrics_force && hasLyrics(path) {
+				skipped++
+				return nil
+			}
+			counter.Total++
+			fetchFileLyrics(path, albums, token, mediaUserToken)
+			return nil
+		})
 		if err != nil {
-			fmt.Println("Failed to run v2:", err)
-			counter.Error++
-			return
+			return err
 		}
 	}
-	tags := []string{
-		"tool=",
-		fmt.Sprintf("artist=%s", track.Resp.Attributes.ArtistName),
+	if skipped > 0 {
+		fmt.Printf("%d file(s) already have lyrics (use --force to replace them)\n", skipped)
 	}
-	if Config.EmbedCover {
-		if (strings.Contains(track.PreID, "pl.") || strings.Contains(track.PreID, "ra.")) && Config.DlAlbumcoverForPlaylist {
-			track.CoverPath, err = writeCover(track.SaveDir, track.ID, track.Resp.Attributes.Artwork.URL)
-			if err != nil {
-				fmt.Println("Failed to write cover.")
-			}
+	return nil
+}
+
+// hasLyrics reports whether the file at path has lyrics everywhere they are
+// configured to go: embedded, and in the file of the first lrc-format.
+func hasLyrics(path string) bool {
+	if Config.EmbedLrc {
+		if lrc, err := library.ReadLyrics(path); err != nil || lrc == "" {
+			return false
 		}
-		tags = append(tags, fmt.Sprintf("cover=%s", track.CoverPath))
 	}
-	tagsString := strings.Join(tags, ":")
-	cmd := exec.Command("MP4Box", "-itags", tagsString, trackPath)
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("Embed failed: %v\n", err)
+	if Config.SaveLrcFile {
+		w, _ := lyrics.Lookup(Config.LrcFormat[0])
+		if exists, _ := fileExists(strings.TrimSuffix(path, filepath.Ext(path)) + w.Ext()); !exists {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchFileLyrics fetches the lyrics of the library file at path.
+func fetchFileLyrics(path string, albums map[int64]*task.Album, token, mediaUserToken string) {
+	t, err := library.ReadTrack(path)
+	if err != nil {
+		fmt.Printf("\u26A0 %s: %v\n", path, err)
 		counter.Error++
 		return
 	}
-	if (strings.Contains(track.PreID, "pl.") || strings.Contains(track.PreID, "ra.")) && Config.DlAlbumcoverForPlaylist {
-		if err := os.Remove(track.CoverPath); err != nil {
-			fmt.Printf("Error deleting file: %s\n", track.CoverPath)
-			counter.Error++
+	// other providers may have lyrics the catalog lacks
+	appleOnly := len(Config.LyricsProviders) == 1 && Config.LyricsProviders[0] == "apple"
+	track := catalogTrack(t, albums, token)
+	if track == nil {
+		if appleOnly {
+			fmt.Println("Not found in the catalog:", path)
+			counter.Unavailable++
 			return
 		}
+		track = &task.Track{Storefront: Config.Storefront}
+		track.Resp.Attributes.Name = t.Title
+		track.Resp.Attributes.ArtistName = t.Artist
+		track.Resp.Attributes.AlbumName = t.Album
+		track.Resp.Attributes.Isrc = t.ISRC
+	}
+	if appleOnly && !track.Resp.Attributes.HasLyrics {
+		fmt.Println("No lyrics in the catalog:", path)
+		counter.Unavailable++
+		return
 	}
-	track.SavePath = trackPath
-	err = writeMP4Tags(track, lrc)
-	if err != nil {
-		fmt.Println("\u26A0 Failed to write tags in media:", err)
+	fmt.Println(filepath.Base(path))
+	track.SaveDir = filepath.Dir(path)
+	track.SaveName = filepath.Base(path)
+	track.SavePath = path
+	lrc, found := trackLyrics(track, token, mediaUserToken)
+	if !found {
 		counter.Unavailable++
 		return
 	}
+	if !Config.EmbedLrc {
+		counter.Success++
+		return
+	}
+	if lrc == "" {
+		counter.Error++
+		return
+	}
+	err = library.EmbedLyrics(path, lrc)
+	if err == nil {
+		// go-mp4tag drops the atoms it does not know
+		err = writeITunesAtoms(track, path)
+	}
+	if err != nil {
+		fmt.Println("\u26A0 Failed to embed lyrics:", err)
+		counter.Error++
+		return
+	}
+	recordHistory(path)
+	recordChecksum(track, path, true)
 	counter.Success++
-	okDict[track.PreID] = append(okDict[track.PreID], track.TaskNum)
+}
+
+// catalogTrack finds the catalog track of a library file: in its album when
+// the tags have an album ID, else by ISRC, preferring a song with the same
+// title. albums caches the albums already fetched.
+func catalogTrack(t history.Track, albums map[int64]*task.Album, token string) *task.Track {
+	if t.AlbumID != 0 {
+		album, ok := albums[t.AlbumID]
+		if !ok {
+			album = task.NewAlbum(Config.Storefront, strconv.FormatInt(t.AlbumID, 10))
+			if err := album.GetResp(token, Config.Language); err != nil {
+				fmt.Printf("\u26A0 Album %d: %v\n", t.AlbumID, err)
+				album = nil
+			}
+			albums[t.AlbumID] = album
+		}
+		if album != nil {
+			if track := matchAlbumTrack(album, t); track != nil && track.Type != "music-videos" {
+				return track
+			}
+		}
+	}
+	if t.ISRC == "" {
+		return nil
+	}
+	resp, err := ampapi.GetSongsByISRC(Config.Storefront, t.ISRC, Config.Language, token)
+	if err != nil || len(resp.Data) == 0 {
+		return nil
+	}
+	song := resp.Data[0]
+	for _, s := range resp.Data {
+		if strings.EqualFold(s.Attributes.Name, t.Title) {
+			song = s
+			break
+		}
+	}
+	track := &task.Track{
+		ID:         song.ID,
+		Type:       song.Type,
+		Name:       song.Attributes.Name,
+		Storefront: Config.Storefront,
+		Language:   Config.Language,
+	}
+	attrs := &track.Resp.Attributes
+	attrs.Name = song.Attributes.Name
+	attrs.ArtistName = song.Attributes.ArtistName
+	attrs.AlbumName = song.Attributes.AlbumName
+	attrs.Isrc = song.Attributes.Isrc
+	attrs.DurationInMillis = song.Attributes.DurationInMillis
+	attrs.HasLyrics = song.Attributes.HasLyrics
+	return track
 }
 
 func ripStation(albumId string, token string, storefront string, mediaUserToken string) error {
@@ -817,6 +2489,7 @@ func ripStation(albumId string, token string, storefront string, mediaUserToken
 		return err
 	}
 	fmt.Println(" -", station.Type)
+	loadSecondaryNames(station.Tracks, storefront, token)
 	meta := station.Resp
 
 	var Codec string
@@ -830,40 +2503,24 @@ func ripStation(albumId string, token string, storefront string, mediaUserToken
 	station.Codec = Codec
 	var singerFoldername string
 	if Config.ArtistFolderFormat != "" {
-		singerFoldername = strings.NewReplacer(
-			"{ArtistName}", "Apple Music Station",
-			"{ArtistId}", "",
-			"{UrlArtistName}", "Apple Music Station",
-		).Replace(Config.ArtistFolderFormat)
-		if strings.HasSuffix(singerFoldername, ".") {
-			singerFoldername = strings.ReplaceAll(singerFoldername, ".", "")
-		}
-		singerFoldername = strings.TrimSpace(singerFoldername)
+		singerFoldername = nameFormats.Artist.ExecuteOr(naming.Data{
+			Artist: naming.Artist{Name: "Apple Music Station", SecondaryName: "Apple Music Station", URLName: "Apple Music Station"},
+		}, "Apple Music Station")
+		singerFoldername = safepath.Name(singerFoldername)
 		fmt.Println(singerFoldername)
 	}
-	singerFolder := filepath.Join(Config.AlacSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
-	if dl_atmos {
-		singerFolder = filepath.Join(Config.AtmosSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
-	}
-	if dl_aac {
-		singerFolder = filepath.Join(Config.AacSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
-	}
+	singerFolder := filepath.Join(saveRoot(), singerFoldername)
 	os.MkdirAll(singerFolder, os.ModePerm)
 	station.SaveDir = singerFolder
 
-	playlistFolder := strings.NewReplacer(
-		"{ArtistName}", "Apple Music Station",
-		"{PlaylistName}", LimitString(station.Name),
-		"{PlaylistId}", station.ID,
-		"{Quality}", "",
-		"{Codec}", Codec,
-		"{Tag}", "",
-	).Replace(Config.PlaylistFolderFormat)
-	if strings.HasSuffix(playlistFolder, ".") {
-		playlistFolder = strings.ReplaceAll(playlistFolder, ".", "")
-	}
-	playlistFolder = strings.TrimSpace(playlistFolder)
-	playlistFolderPath := filepath.Join(singerFolder, forbiddenNames.ReplaceAllString(playlistFolder, "_"))
+	stationData := naming.Data{
+		Artist:   naming.Artist{Name: "Apple Music Station", SecondaryName: "Apple Music Station", URLName: "Apple Music Station"},
+		Playlist: naming.Playlist{ID: station.ID, Name: station.Name, CuratorName: "Apple Music Station"},
+		Codec:    Codec,
+	}
+	playlistFolder := nameFormats.Playlist.ExecuteOr(stationData, station.ID)
+	playlistFolder = safepath.Name(playlistFolder)
+	playlistFolderPath := safepath.Join(singerFolder, playlistFolder)
 	os.MkdirAll(playlistFolderPath, os.ModePerm)
 	station.SaveName = playlistFolder
 	fmt.Println(playlistFolder)
@@ -889,8 +2546,7 @@ func ripStation(albumId string, token string, storefront string, mediaUserToken
 				fmt.Println("Animated artwork square already exists locally.")
 			} else {
 				fmt.Println("Animation Artwork Square Downloading...")
-				cmd := exec.Command("ffmpeg", "-loglevel", "quiet", "-y", "-i", motionvideoUrlSquare, "-c", "copy", filepath.Join(playlistFolderPath, "square_animated_artwork.mp4"))
-				if err := cmd.Run(); err != nil {
+				if err := tools.Default.FFmpeg.Copy(motionvideoUrlSquare, filepath.Join(playlistFolderPath, "square_animated_artwork.mp4")); err != nil {
 					fmt.Printf("animated artwork square dl err: %v\n", err)
 				} else {
 					fmt.Println("Animation Artwork Square Downloaded")
@@ -899,8 +2555,7 @@ func ripStation(albumId string, token string, storefront string, mediaUserToken
 		}
 
 		if Config.EmbyAnimatedArtwork {
-			cmd3 := exec.Command("ffmpeg", "-i", filepath.Join(playlistFolderPath, "square_animated_artwork.mp4"), "-vf", "scale=440:-1", "-r", "24", "-f", "gif", filepath.Join(playlistFolderPath, "folder.jpg"))
-			if err := cmd3.Run(); err != nil {
+			if err := tools.Default.FFmpeg.Gif(filepath.Join(playlistFolderPath, "square_animated_artwork.mp4"), filepath.Join(playlistFolderPath, "folder.jpg"), 440, 24); err != nil {
 				fmt.Printf("animated artwork square to gif err: %v\n", err)
 			}
 		}
@@ -911,18 +2566,24 @@ func ripStation(albumId string, token string, storefront string, mediaUserToken
 			counter.Success++
 			return nil
 		}
-		songName := strings.NewReplacer(
-			"{SongId}", station.ID,
-			"{SongNumer}", "01",
-			"{SongName}", LimitString(station.Name),
-			"{DiscNumber}", "1",
-			"{TrackNumber}", "1",
-			"{Quality}", "256Kbps",
-			"{Tag}", "",
-			"{Codec}", "AAC",
-		).Replace(Config.SongFileFormat)
+		streamData := stationData
+		streamData.Track = naming.Track{
+			ID:          station.ID,
+			Name:        station.Name,
+			ArtistName:  "Apple Music Station",
+			AlbumName:   station.Name,
+			Position:    "01",
+			TaskNum:     1,
+			TaskTotal:   1,
+			TrackNumber: 1,
+			DiscNumber:  1,
+			DiscTotal:   1,
+		}
+		streamData.Quality = "256Kbps"
+		streamData.Codec = "AAC"
+		songName := nameFormats.Song.ExecuteOr(streamData, station.ID)
 		fmt.Println(songName)
-		trackPath := filepath.Join(playlistFolderPath, fmt.Sprintf("%s.m4a", forbiddenNames.ReplaceAllString(songName, "_")))
+		trackPath := safepath.Join(playlistFolderPath, safepath.File(songName, ".m4a"))
 		exists, _ := fileExists(trackPath)
 		if exists {
 			counter.Success++
@@ -959,9 +2620,7 @@ func ripStation(albumId string, token string, storefront string, mediaUserToken
 		if Config.EmbedCover {
 			tags = append(tags, fmt.Sprintf("cover=%s", station.CoverPath))
 		}
-		tagsString := strings.Join(tags, ":")
-		cmd := exec.Command("MP4Box", "-itags", tagsString, trackPath)
-		if err := cmd.Run(); err != nil {
+		if err := tools.Default.MP4Box.ITags(trackPath, tags); err != nil {
 			fmt.Printf("Embed failed: %v\n", err)
 		}
 		counter.Success++
@@ -974,24 +2633,264 @@ func ripStation(albumId string, token string, storefront string, mediaUserToken
 		station.Tracks[i].SaveDir = playlistFolderPath
 		station.Tracks[i].Codec = Codec
 	}
+	if err := planTrackPaths(station.ID, station.Tracks); err != nil {
+		fmt.Println(err)
+		counter.Error++
+		return err
+	}
 
 	trackTotal := len(station.Tracks)
 	arr := make([]int, trackTotal)
 	for i := 0; i < trackTotal; i++ {
 		arr[i] = i + 1
 	}
-	var selected []int
+	var selected []int
+
+	if true {
+		selected = arr
+	}
+	for i := range station.Tracks {
+		i++
+		if isInArray(selected, i) {
+			ripTrack(&station.Tracks[i-1], token, mediaUserToken)
+		}
+	}
+	return nil
+}
+
+// albumLayout is where an album is saved, following the artist and album
+// folder formats.
+type albumLayout struct {
+	Data      naming.Data
+	Codec     string
+	ArtistDir string
+	Name      string // album folder name
+	Dir       string
+	Cover     string // set by callers that save the cover
+}
+
+// trackAlbumDir is the folder of an album track: the album folder, or its
+// disc subfolder for multi-disc albums when disc-folder-format is set.
+func trackAlbumDir(track *task.Track, layout albumLayout) string {
+	if !useDiscFolders(track) {
+		return layout.Dir
+	}
+	discData := layout.Data
+	discData.Track = trackNameData(track).Track
+	return safepath.Join(layout.Dir, nameFormats.Disc.ExecuteOr(discData, fmt.Sprintf("Disc %d", discData.Track.DiscNumber)))
+}
+
+// planAlbumLayout computes and creates the album folders. When the album
+// format uses Quality it is read from the first track's manifest.
+func planAlbumLayout(albumId, storefront, language, token string, album ampapi.AlbumRespData) albumLayout {
+	var Codec string
+	if dl_atmos {
+		Codec = "ATMOS"
+	} else if dl_aac {
+		Codec = "AAC"
+	} else {
+		Codec = "ALAC"
+	}
+	albumData := naming.Data{
+		Artist: naming.Artist{
+			Name:    album.Attributes.ArtistName,
+			URLName: album.Attributes.ArtistName,
+		},
+		Album: albumNameData(albumId, album),
+		Codec: Codec,
+	}
+	albumData.Artist.SecondaryName = album.Attributes.ArtistName
+	if len(album.Relationships.Artists.Data) > 0 {
+		albumData.Artist.ID = album.Relationships.Artists.Data[0].ID
+	}
+	if useSecondary() && len(album.Relationships.Tracks.Data) > 0 &&
+		(nameFormats.Album.Uses("SecondaryName", "SecondaryArtistName") || nameFormats.Artist.Uses("SecondaryName")) {
+		id := album.Relationships.Tracks.Data[0].ID
+		names, err := fetchSecondaryNames(storefront, []string{id}, token)
+		if err != nil {
+			fmt.Println("Failed to get names in the secondary language:", err)
+		}
+		if n, ok := names[id]; ok {
+			albumData.Album.SecondaryName = cmp.Or(n.AlbumName, albumData.Album.Name)
+			albumData.Album.SecondaryArtistName = cmp.Or(n.AlbumArtistName, albumData.Album.ArtistName)
+			albumData.Artist.SecondaryName = albumData.Album.SecondaryArtistName
+		}
+	}
+	if urlArtist.URLName != "" {
+		albumData.Artist.URLName = urlArtist.URLName
+		albumData.Artist.ID = urlArtist.ID
+	}
+	var singerFoldername string
+	if Config.ArtistFolderFormat != "" {
+		singerFoldername = nameFormats.Artist.ExecuteOr(albumData, cmp.Or(albumData.Artist.ID, albumData.Artist.Name))
+		singerFoldername = safepath.Name(singerFoldername)
+		fmt.Println(singerFoldername)
+	}
+	singerFolder := filepath.Join(saveRoot(), singerFoldername)
+	os.MkdirAll(singerFolder, os.ModePerm)
+	var Quality string
+	if nameFormats.Album.Uses("Quality") {
+		if dl_atmos {
+			Quality = fmt.Sprintf("%dKbps", Config.AtmosMax-2000)
+		} else if dl_aac && Config.AacType == "aac-lc" {
+			Quality = "256Kbps"
+		} else {
+			manifest1, err := ampapi.GetSongResp(storefront, album.Relationships.Tracks.Data[0].ID, language, token)
+			if err != nil {
+				fmt.Println("Failed to get manifest.\n", err)
+			} else {
+				if manifest1.Data[0].Attributes.ExtendedAssetUrls.EnhancedHls == "" {
+					Codec = "AAC"
+					Quality = "256Kbps"
+				} else {
+					needCheck := false
+
+					if Config.GetM3u8Mode == "all" {
+						needCheck = true
+					} else if Config.GetM3u8Mode == "hires" && contains(album.Relationships.Tracks.Data[0].Attributes.AudioTraits, "hi-res-lossless") {
+						needCheck = true
+					}
+					var EnhancedHls_m3u8 string
+					if needCheck {
+						EnhancedHls_m3u8, _ = checkM3u8(album.Relationships.Tracks.Data[0].ID, "album")
+						if strings.HasSuffix(EnhancedHls_m3u8, ".m3u8") {
+							manifest1.Data[0].Attributes.ExtendedAssetUrls.EnhancedHls = EnhancedHls_m3u8
+						}
+					}
+					_, Quality, err = extractMedia(manifest1.Data[0].Attributes.ExtendedAssetUrls.EnhancedHls, true)
+					if err != nil {
+						fmt.Println("Failed to extract quality from manifest.\n", err)
+					}
+				}
+			}
+		}
+	}
+	stringsToJoin := []string{}
+	if album.Attributes.IsAppleDigitalMaster || album.Attributes.IsMasteredForItunes {
+		if Config.AppleMasterChoice != "" {
+			stringsToJoin = append(stringsToJoin, Config.AppleMasterChoice)
+		}
+	}
+	if album.Attributes.ContentRating == "explicit" {
+		if Config.ExplicitChoice != "" {
+			stringsToJoin = append(stringsToJoin, Config.ExplicitChoice)
+		}
+	}
+	if album.Attributes.ContentRating == "clean" {
+		if Config.CleanChoice != "" {
+			stringsToJoin = append(stringsToJoin, Config.CleanChoice)
+		}
+	}
+	Tag_string := strings.Join(stringsToJoin, " ")
+	albumData.Quality = Quality
+	albumData.Codec = Codec
+	albumData.Tag = Tag_string
+	albumFolderName := nameFormats.Album.ExecuteOr(albumData, albumId)
+
+	albumFolderName = safepath.Name(albumFolderName)
+	albumFolderPath := safepath.Join(singerFolder, albumFolderName)
+	os.MkdirAll(albumFolderPath, os.ModePerm)
+	fmt.Println(albumFolderName)
+	return albumLayout{
+		Data:      albumData,
+		Codec:     Codec,
+		ArtistDir: singerFolder,
+		Name:      albumFolderName,
+		Dir:       albumFolderPath,
+	}
+}
+
+var (
+	notesBreak = regexp.MustCompile(`(?i)<br\s*/?>|</p>`)
+	notesTag   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// editorialText converts the markup of catalog editorial notes to plain text.
+func editorialText(notes string) string {
+	notes = notesBreak.ReplaceAllString(notes, "\n")
+	notes = notesTag.ReplaceAllString(notes, "")
+	return strings.TrimSpace(html.UnescapeString(notes))
+}
+
+// albumNotes returns the short and standard editorial notes of the album a
+// track is tagged with, or nothing for playlist entries tagged as such.
+func albumNotes(track *task.Track) (short, long string) {
+	if track.PreType != "albums" && !Config.UseSongInfoForPlaylist {
+		return "", ""
+	}
+	notes := track.AlbumData.Attributes.EditorialNotes
+	long = editorialText(notes.Standard)
+	short = cmp.Or(editorialText(notes.Short), long)
+	return short, long
+}
 
-	if true {
-		selected = arr
+// writeAlbumSidecars writes album.nfo and artist.nfo when save-nfo is set,
+// notes.txt with the album's editorial notes when save-notes is set, and
+// album.json with the raw catalog record when save-album-json is set.
+// artist.nfo needs an artist folder. cover and artistCover are the images
+// already saved, or empty.
+func writeAlbumSidecars(layout albumLayout, album ampapi.AlbumRespData, cover, artistCover string) {
+	attrs := album.Attributes
+	if Config.SaveNfo {
+		a := nfo.Album{
+			Title:       attrs.Name,
+			Artist:      attrs.ArtistName,
+			Genres:      genrePolicy.Names(attrs.GenreNames),
+			Label:       attrs.RecordLabel,
+			ReleaseDate: attrs.ReleaseDate,
+			Compilation: attrs.IsCompilation,
+			Single:      attrs.IsSingle,
+			Copyright:   attrs.Copyright,
+			UPC:         attrs.Upc,
+			AppleID:     album.ID,
+			Review:      editorialText(attrs.EditorialNotes.Standard),
+		}
+		if cover != "" {
+			a.Thumb = filepath.Base(cover)
+		}
+		for _, t := range album.Relationships.Tracks.Data {
+			if t.Type == "music-videos" {
+				continue
+			}
+			a.Tracks = append(a.Tracks, nfo.Track{
+				Disc:       t.Attributes.DiscNumber,
+				Position:   t.Attributes.TrackNumber,
+				Title:      t.Attributes.Name,
+				DurationMs: t.Attributes.DurationInMillis,
+			})
+		}
+		if err := nfo.WriteAlbum(filepath.Join(layout.Dir, "album.nfo"), a); err != nil {
+			fmt.Println("Failed to write album.nfo:", err)
+		}
+		if Config.ArtistFolderFormat != "" && len(album.Relationships.Artists.Data) > 0 {
+			artist := album.Relationships.Artists.Data[0]
+			ar := nfo.Artist{
+				Name:    artist.Attributes.Name,
+				AppleID: artist.ID,
+				Genres:  genrePolicy.Names(attrs.GenreNames),
+			}
+			if artistCover != "" {
+				ar.Thumb = filepath.Base(artistCover)
+			}
+			if err := nfo.WriteArtist(filepath.Join(layout.ArtistDir, "artist.nfo"), ar); err != nil {
+				fmt.Println("Failed to write artist.nfo:", err)
+			}
+		}
 	}
-	for i := range station.Tracks {
-		i++
-		if isInArray(selected, i) {
-			ripTrack(&station.Tracks[i-1], token, mediaUserToken)
+	if notes := editorialText(attrs.EditorialNotes.Standard); Config.SaveNotes && notes != "" {
+		if err := os.WriteFile(filepath.Join(layout.Dir, "notes.txt"), []byte(notes+"\n"), 0644); err != nil {
+			fmt.Println("Failed to write notes.txt:", err)
+		}
+	}
+	if Config.SaveAlbumJson {
+		data, err := json.MarshalIndent(album, "", "  ")
+		if err == nil {
+			err = os.WriteFile(filepath.Join(layout.Dir, "album.json"), append(data, '\n'), 0644)
+		}
+		if err != nil {
+			fmt.Println("Failed to write album.json:", err)
 		}
 	}
-	return nil
 }
 
 func ripAlbum(albumId string, token string, storefront string, mediaUserToken string, urlArg_i string) error {
@@ -1001,6 +2900,7 @@ func ripAlbum(albumId string, token string, storefront string, mediaUserToken st
 		fmt.Println("Failed to get album response.")
 		return err
 	}
+	loadSecondaryNames(album.Tracks, storefront, token)
 	meta := album.Resp
 	if debug_mode {
 		fmt.Println(meta.Data[0].Attributes.ArtistName)
@@ -1044,125 +2944,18 @@ func ripAlbum(albumId string, token string, storefront string, mediaUserToken st
 		}
 		return nil
 	}
-	var Codec string
-	if dl_atmos {
-		Codec = "ATMOS"
-	} else if dl_aac {
-		Codec = "AAC"
-	} else {
-		Codec = "ALAC"
-	}
+	layout := planAlbumLayout(albumId, storefront, album.Language, token, meta.Data[0])
+	Codec := layout.Codec
 	album.Codec = Codec
-	var singerFoldername string
-	if Config.ArtistFolderFormat != "" {
-		if len(meta.Data[0].Relationships.Artists.Data) > 0 {
-			singerFoldername = strings.NewReplacer(
-				"{UrlArtistName}", LimitString(meta.Data[0].Attributes.ArtistName),
-				"{ArtistName}", LimitString(meta.Data[0].Attributes.ArtistName),
-				"{ArtistId}", meta.Data[0].Relationships.Artists.Data[0].ID,
-			).Replace(Config.ArtistFolderFormat)
-		} else {
-			singerFoldername = strings.NewReplacer(
-				"{UrlArtistName}", LimitString(meta.Data[0].Attributes.ArtistName),
-				"{ArtistName}", LimitString(meta.Data[0].Attributes.ArtistName),
-				"{ArtistId}", "",
-			).Replace(Config.ArtistFolderFormat)
-		}
-		if strings.HasSuffix(singerFoldername, ".") {
-			singerFoldername = strings.ReplaceAll(singerFoldername, ".", "")
-		}
-		singerFoldername = strings.TrimSpace(singerFoldername)
-		fmt.Println(singerFoldername)
-	}
-	singerFolder := filepath.Join(Config.AlacSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
-	if dl_atmos {
-		singerFolder = filepath.Join(Config.AtmosSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
-	}
-	if dl_aac {
-		singerFolder = filepath.Join(Config.AacSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
-	}
-	os.MkdirAll(singerFolder, os.ModePerm)
+	singerFolder := layout.ArtistDir
 	album.SaveDir = singerFolder
-	var Quality string
-	if strings.Contains(Config.AlbumFolderFormat, "Quality") {
-		if dl_atmos {
-			Quality = fmt.Sprintf("%dKbps", Config.AtmosMax-2000)
-		} else if dl_aac && Config.AacType == "aac-lc" {
-			Quality = "256Kbps"
-		} else {
-			manifest1, err := ampapi.GetSongResp(storefront, meta.Data[0].Relationships.Tracks.Data[0].ID, album.Language, token)
-			if err != nil {
-				fmt.Println("Failed to get manifest.\n", err)
-			} else {
-				if manifest1.Data[0].Attributes.ExtendedAssetUrls.EnhancedHls == "" {
-					Codec = "AAC"
-					Quality = "256Kbps"
-				} else {
-					needCheck := false
-
-					if Config.GetM3u8Mode == "all" {
-						needCheck = true
-					} else if Config.GetM3u8Mode == "hires" && contains(meta.Data[0].Relationships.Tracks.Data[0].Attributes.AudioTraits, "hi-res-lossless") {
-						needCheck = true
-					}
-					var EnhancedHls_m3u8 string
-					if needCheck {
-						EnhancedHls_m3u8, _ = checkM3u8(meta.Data[0].Relationships.Tracks.Data[0].ID, "album")
-						if strings.HasSuffix(EnhancedHls_m3u8, ".m3u8") {
-							manifest1.Data[0].Attributes.ExtendedAssetUrls.EnhancedHls = EnhancedHls_m3u8
-						}
-					}
-					_, Quality, err = extractMedia(manifest1.Data[0].Attributes.ExtendedAssetUrls.EnhancedHls, true)
-					if err != nil {
-						fmt.Println("Failed to extract quality from manifest.\n", err)
-					}
-				}
-			}
-		}
-	}
-	stringsToJoin := []string{}
-	if meta.Data[0].Attributes.IsAppleDigitalMaster || meta.Data[0].Attributes.IsMasteredForItunes {
-		if Config.AppleMasterChoice != "" {
-			stringsToJoin = append(stringsToJoin, Config.AppleMasterChoice)
-		}
-	}
-	if meta.Data[0].Attributes.ContentRating == "explicit" {
-		if Config.ExplicitChoice != "" {
-			stringsToJoin = append(stringsToJoin, Config.ExplicitChoice)
-		}
-	}
-	if meta.Data[0].Attributes.ContentRating == "clean" {
-		if Config.CleanChoice != "" {
-			stringsToJoin = append(stringsToJoin, Config.CleanChoice)
-		}
-	}
-	Tag_string := strings.Join(stringsToJoin, " ")
-	var albumFolderName string
-	albumFolderName = strings.NewReplacer(
-		"{ReleaseDate}", meta.Data[0].Attributes.ReleaseDate,
-		"{ReleaseYear}", meta.Data[0].Attributes.ReleaseDate[:4],
-		"{ArtistName}", LimitString(meta.Data[0].Attributes.ArtistName),
-		"{AlbumName}", LimitString(meta.Data[0].Attributes.Name),
-		"{UPC}", meta.Data[0].Attributes.Upc,
-		"{RecordLabel}", meta.Data[0].Attributes.RecordLabel,
-		"{Copyright}", meta.Data[0].Attributes.Copyright,
-		"{AlbumId}", albumId,
-		"{Quality}", Quality,
-		"{Codec}", Codec,
-		"{Tag}", Tag_string,
-	).Replace(Config.AlbumFolderFormat)
-
-	if strings.HasSuffix(albumFolderName, ".") {
-		albumFolderName = strings.ReplaceAll(albumFolderName, ".", "")
-	}
-	albumFolderName = strings.TrimSpace(albumFolderName)
-	albumFolderPath := filepath.Join(singerFolder, forbiddenNames.ReplaceAllString(albumFolderName, "_"))
-	os.MkdirAll(albumFolderPath, os.ModePerm)
+	albumFolderName := layout.Name
+	albumFolderPath := layout.Dir
 	album.SaveName = albumFolderName
-	fmt.Println(albumFolderName)
+	var artistCovPath string
 	if Config.SaveArtistCover {
 		if len(meta.Data[0].Relationships.Artists.Data) > 0 {
-			_, err = writeCover(singerFolder, "folder", meta.Data[0].Relationships.Artists.Data[0].Attributes.Artwork.Url)
+			artistCovPath, err = writeCover(singerFolder, "folder", meta.Data[0].Relationships.Artists.Data[0].Attributes.Artwork.Url)
 			if err != nil {
 				fmt.Println("Failed to write artist cover.")
 			}
@@ -1172,6 +2965,7 @@ func ripAlbum(albumId string, token string, storefront string, mediaUserToken st
 	if err != nil {
 		fmt.Println("Failed to write cover.")
 	}
+	writeAlbumSidecars(layout, meta.Data[0], covPath, artistCovPath)
 	if Config.SaveAnimatedArtwork && meta.Data[0].Attributes.EditorialVideo.MotionDetailSquare.Video != "" {
 		fmt.Println("Found Animation Artwork.")
 
@@ -1187,8 +2981,7 @@ func ripAlbum(albumId string, token string, storefront string, mediaUserToken st
 				fmt.Println("Animated artwork square already exists locally.")
 			} else {
 				fmt.Println("Animation Artwork Square Downloading...")
-				cmd := exec.Command("ffmpeg", "-loglevel", "quiet", "-y", "-i", motionvideoUrlSquare, "-c", "copy", filepath.Join(albumFolderPath, "square_animated_artwork.mp4"))
-				if err := cmd.Run(); err != nil {
+				if err := tools.Default.FFmpeg.Copy(motionvideoUrlSquare, filepath.Join(albumFolderPath, "square_animated_artwork.mp4")); err != nil {
 					fmt.Printf("animated artwork square dl err: %v\n", err)
 				} else {
 					fmt.Println("Animation Artwork Square Downloaded")
@@ -1197,8 +2990,7 @@ func ripAlbum(albumId string, token string, storefront string, mediaUserToken st
 		}
 
 		if Config.EmbyAnimatedArtwork {
-			cmd3 := exec.Command("ffmpeg", "-i", filepath.Join(albumFolderPath, "square_animated_artwork.mp4"), "-vf", "scale=440:-1", "-r", "24", "-f", "gif", filepath.Join(albumFolderPath, "folder.jpg"))
-			if err := cmd3.Run(); err != nil {
+			if err := tools.Default.FFmpeg.Gif(filepath.Join(albumFolderPath, "square_animated_artwork.mp4"), filepath.Join(albumFolderPath, "folder.jpg"), 440, 24); err != nil {
 				fmt.Printf("animated artwork square to gif err: %v\n", err)
 			}
 		}
@@ -1215,8 +3007,7 @@ func ripAlbum(albumId string, token string, storefront string, mediaUserToken st
 				fmt.Println("Animated artwork tall already exists locally.")
 			} else {
 				fmt.Println("Animation Artwork Tall Downloading...")
-				cmd := exec.Command("ffmpeg", "-loglevel", "quiet", "-y", "-i", motionvideoUrlTall, "-c", "copy", filepath.Join(albumFolderPath, "tall_animated_artwork.mp4"))
-				if err := cmd.Run(); err != nil {
+				if err := tools.Default.FFmpeg.Copy(motionvideoUrlTall, filepath.Join(albumFolderPath, "tall_animated_artwork.mp4")); err != nil {
 					fmt.Printf("animated artwork tall dl err: %v\n", err)
 				} else {
 					fmt.Println("Animation Artwork Tall Downloaded")
@@ -1228,6 +3019,12 @@ func ripAlbum(albumId string, token string, storefront string, mediaUserToken st
 		album.Tracks[i].CoverPath = covPath
 		album.Tracks[i].SaveDir = albumFolderPath
 		album.Tracks[i].Codec = Codec
+		album.Tracks[i].SaveDir = trackAlbumDir(&album.Tracks[i], layout)
+	}
+	if err := planTrackPaths(albumId, album.Tracks); err != nil {
+		fmt.Println(err)
+		counter.Error++
+		return err
 	}
 	trackTotal := len(meta.Data[0].Relationships.Tracks.Data)
 	arr := make([]int, trackTotal)
@@ -1268,12 +3065,22 @@ func ripAlbum(albumId string, token string, storefront string, mediaUserToken st
 
 }
 func ripPlaylist(playlistId string, token string, storefront string, mediaUserToken string) error {
+	return ripPlaylistWith(playlistId, token, storefront, mediaUserToken, nil)
+}
+
+// playlistHook runs after the paths of a playlist's tracks are planned and
+// before any is downloaded. base is the playlist folder, or the playlist
+// file path without extension in library mode.
+type playlistHook func(playlist *task.Playlist, base string) error
+
+func ripPlaylistWith(playlistId string, token string, storefront string, mediaUserToken string, hook playlistHook) error {
 	playlist := task.NewPlaylist(storefront, playlistId)
 	err := playlist.GetResp(token, Config.Language)
 	if err != nil {
 		fmt.Println("Failed to get playlist response.")
 		return err
 	}
+	loadSecondaryNames(playlist.Tracks, storefront, token)
 	meta := playlist.Resp
 	if debug_mode {
 		fmt.Println(meta.Data[0].Attributes.ArtistName)
@@ -1326,31 +3133,27 @@ func ripPlaylist(playlistId string, token string, storefront string, mediaUserTo
 		Codec = "ALAC"
 	}
 	playlist.Codec = Codec
+	playlistData := naming.Data{
+		Artist: naming.Artist{Name: "Apple Music", SecondaryName: "Apple Music", URLName: "Apple Music"},
+		Playlist: naming.Playlist{
+			ID:          playlistId,
+			Name:        meta.Data[0].Attributes.Name,
+			CuratorName: meta.Data[0].Attributes.ArtistName,
+			TrackCount:  len(meta.Data[0].Relationships.Tracks.Data),
+		},
+	}
 	var singerFoldername string
 	if Config.ArtistFolderFormat != "" {
-		singerFoldername = strings.NewReplacer(
-			"{ArtistName}", "Apple Music",
-			"{ArtistId}", "",
-			"{UrlArtistName}", "Apple Music",
-		).Replace(Config.ArtistFolderFormat)
-		if strings.HasSuffix(singerFoldername, ".") {
-			singerFoldername = strings.ReplaceAll(singerFoldername, ".", "")
-		}
-		singerFoldername = strings.TrimSpace(singerFoldername)
+		singerFoldername = nameFormats.Artist.ExecuteOr(playlistData, playlistData.Artist.Name)
+		singerFoldername = safepath.Name(singerFoldername)
 		fmt.Println(singerFoldername)
 	}
-	singerFolder := filepath.Join(Config.AlacSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
-	if dl_atmos {
-		singerFolder = filepath.Join(Config.AtmosSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
-	}
-	if dl_aac {
-		singerFolder = filepath.Join(Config.AacSaveFolder, forbiddenNames.ReplaceAllString(singerFoldername, "_"))
-	}
+	singerFolder := filepath.Join(saveRoot(), singerFoldername)
 	os.MkdirAll(singerFolder, os.ModePerm)
 	playlist.SaveDir = singerFolder
 
 	var Quality string
-	if strings.Contains(Config.AlbumFolderFormat, "Quality") {
+	if nameFormats.Playlist.Uses("Quality") {
 		if dl_atmos {
 			Quality = fmt.Sprintf("%dKbps", Config.AtmosMax-2000)
 		} else if dl_aac && Config.AacType == "aac-lc" {
@@ -1403,19 +3206,15 @@ func ripPlaylist(playlistId string, token string, storefront string, mediaUserTo
 		}
 	}
 	Tag_string := strings.Join(stringsToJoin, " ")
-	playlistFolder := strings.NewReplacer(
-		"{ArtistName}", "Apple Music",
-		"{PlaylistName}", LimitString(meta.Data[0].Attributes.Name),
-		"{PlaylistId}", playlistId,
-		"{Quality}", Quality,
-		"{Codec}", Codec,
-		"{Tag}", Tag_string,
-	).Replace(Config.PlaylistFolderFormat)
-	if strings.HasSuffix(playlistFolder, ".") {
-		playlistFolder = strings.ReplaceAll(playlistFolder, ".", "")
-	}
-	playlistFolder = strings.TrimSpace(playlistFolder)
-	playlistFolderPath := filepath.Join(singerFolder, forbiddenNames.ReplaceAllString(playlistFolder, "_"))
+	playlistData.Quality = Quality
+	playlistData.Codec = Codec
+	playlistData.Tag = Tag_string
+	playlistFolder := nameFormats.Playlist.ExecuteOr(playlistData, playlistId)
+	playlistFolder = safepath.Name(playlistFolder)
+	if Config.PlaylistMode == "library" {
+		return ripPlaylistToLibrary(playlist, singerFolder, playlistFolder, token, mediaUserToken, hook)
+	}
+	playlistFolderPath := safepath.Join(singerFolder, playlistFolder)
 	os.MkdirAll(playlistFolderPath, os.ModePerm)
 	playlist.SaveName = playlistFolder
 	fmt.Println(playlistFolder)
@@ -1429,6 +3228,16 @@ func ripPlaylist(playlistId string, token string, storefront string, mediaUserTo
 		playlist.Tracks[i].SaveDir = playlistFolderPath
 		playlist.Tracks[i].Codec = Codec
 	}
+	if err := planTrackPaths(playlistId, playlist.Tracks); err != nil {
+		fmt.Println(err)
+		counter.Error++
+		return err
+	}
+	if hook != nil {
+		if err := hook(playlist, playlistFolderPath); err != nil {
+			return err
+		}
+	}
 
 	if Config.SaveAnimatedArtwork && meta.Data[0].Attributes.EditorialVideo.MotionDetailSquare.Video != "" {
 		fmt.Println("Found Animation Artwork.")
@@ -1445,8 +3254,7 @@ func ripPlaylist(playlistId string, token string, storefront string, mediaUserTo
 				fmt.Println("Animated artwork square already exists locally.")
 			} else {
 				fmt.Println("Animation Artwork Square Downloading...")
-				cmd := exec.Command("ffmpeg", "-loglevel", "quiet", "-y", "-i", motionvideoUrlSquare, "-c", "copy", filepath.Join(playlistFolderPath, "square_animated_artwork.mp4"))
-				if err := cmd.Run(); err != nil {
+				if err := tools.Default.FFmpeg.Copy(motionvideoUrlSquare, filepath.Join(playlistFolderPath, "square_animated_artwork.mp4")); err != nil {
 					fmt.Printf("animated artwork square dl err: %v\n", err)
 				} else {
 					fmt.Println("Animation Artwork Square Downloaded")
@@ -1455,8 +3263,7 @@ func ripPlaylist(playlistId string, token string, storefront string, mediaUserTo
 		}
 
 		if Config.EmbyAnimatedArtwork {
-			cmd3 := exec.Command("ffmpeg", "-i", filepath.Join(playlistFolderPath, "square_animated_artwork.mp4"), "-vf", "scale=440:-1", "-r", "24", "-f", "gif", filepath.Join(playlistFolderPath, "folder.jpg"))
-			if err := cmd3.Run(); err != nil {
+			if err := tools.Default.FFmpeg.Gif(filepath.Join(playlistFolderPath, "square_animated_artwork.mp4"), filepath.Join(playlistFolderPath, "folder.jpg"), 440, 24); err != nil {
 				fmt.Printf("animated artwork square to gif err: %v\n", err)
 			}
 		}
@@ -1473,8 +3280,7 @@ func ripPlaylist(playlistId string, token string, storefront string, mediaUserTo
 				fmt.Println("Animated artwork tall already exists locally.")
 			} else {
 				fmt.Println("Animation Artwork Tall Downloading...")
-				cmd := exec.Command("ffmpeg", "-loglevel", "quiet", "-y", "-i", motionvideoUrlTall, "-c", "copy", filepath.Join(playlistFolderPath, "tall_animated_artwork.mp4"))
-				if err := cmd.Run(); err != nil {
+				if err := tools.Default.FFmpeg.Copy(motionvideoUrlTall, filepath.Join(playlistFolderPath, "tall_animated_artwork.mp4")); err != nil {
 					fmt.Printf("animated artwork tall dl err: %v\n", err)
 				} else {
 					fmt.Println("Animation Artwork Tall Downloaded")
@@ -1508,6 +3314,252 @@ func ripPlaylist(playlistId string, token string, storefront string, mediaUserTo
 	return nil
 }
 
+// ripPlaylistToLibrary files the playlist's tracks into the artist/album
+// layout used by ripAlbum, reusing tracks already there, and then writes
+// playlist files referencing them in playlist order. A song shared by
+// several playlists is therefore stored once.
+func ripPlaylistToLibrary(playlist *task.Playlist, dir, name, token, mediaUserToken string, hook playlistHook) error {
+	layouts := make(map[string]albumLayout)
+	for i := range playlist.Tracks {
+		track := &playlist.Tracks[i]
+		if track.Type == "music-videos" {
+			track.SaveDir = filepath.Join(dir, name)
+			continue
+		}
+		if err := moveToLibrary(track, layouts, token); err != nil {
+			fmt.Printf("Failed to get album of track %d, saving it in the playlist folder: %v\n", track.TaskNum, err)
+			track.SaveDir = filepath.Join(dir, name)
+			track.Codec = playlist.Codec
+		}
+	}
+	if err := planTrackPaths(playlist.ID, playlist.Tracks); err != nil {
+		fmt.Println(err)
+		counter.Error++
+		return err
+	}
+	if hook != nil {
+		if err := hook(playlist, filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+
+	selected := make([]int, len(playlist.Tracks))
+	for i := range selected {
+		selected[i] = i + 1
+	}
+	if dl_select {
+		selected = playlist.ShowSelect()
+	}
+	for i := range playlist.Tracks {
+		if isInArray(selected, i+1) {
+			ripTrack(&playlist.Tracks[i], token, mediaUserToken)
+		}
+	}
+	return writePlaylistFiles(playlist, filepath.Join(dir, name))
+}
+
+// moveToLibrary turns a playlist track into a track of its album, saved
+// where ripAlbum would save it and tagged with the album's information.
+func moveToLibrary(track *task.Track, layouts map[string]albumLayout, token string) error {
+	if err := track.GetAlbumData(token); err != nil {
+		return err
+	}
+	albumID := track.AlbumData.ID
+	layout, ok := layouts[albumID]
+	if !ok {
+		layout = planAlbumLayout(albumID, track.Storefront, track.Language, token, track.AlbumData)
+		covPath, err := writeCover(layout.Dir, "cover", track.AlbumData.Attributes.Artwork.URL)
+		if err != nil {
+			fmt.Println("Failed to write cover.")
+		}
+		layout.Cover = covPath
+		layouts[albumID] = layout
+		writeAlbumSidecars(layout, track.AlbumData, covPath, "")
+	}
+	albumTracks := track.AlbumData.Relationships.Tracks.Data
+	track.PreType = "albums"
+	track.PreID = albumID
+	track.TaskTotal = len(albumTracks)
+	for i := range albumTracks {
+		if albumTracks[i].ID == track.ID {
+			track.TaskNum = i + 1
+			break
+		}
+	}
+	track.Codec = layout.Codec
+	track.CoverPath = layout.Cover
+	track.SaveDir = trackAlbumDir(track, layout)
+	return nil
+}
+
+// writePlaylistFiles writes the playlist-formats files for the tracks that
+// are present in the library, in playlist order. Tracks not ripped in this
+// run, whose name may depend on a Quality that is unknown, are looked up in
+// the history store first.
+func writePlaylistFiles(playlist *task.Playlist, base string) error {
+	attrs := playlist.Resp.Data[0].Attributes
+	p := playlistfile.Playlist{Title: attrs.Name, Curator: attrs.ArtistName}
+	for i := range playlist.Tracks {
+		track := &playlist.Tracks[i]
+		if track.Type == "music-videos" {
+			continue
+		}
+		path := track.SavePath
+		if path == "" {
+			if owned, ok := findOwned(track); ok {
+				path = owned.Path
+			} else {
+				path = trackFilePath(track)
+			}
+		}
+		if exists, _ := fileExists(path); !exists {
+			continue
+		}
+		p.Entries = append(p.Entries, playlistfile.Entry{
+			Path:       path,
+			Title:      track.Resp.Attributes.Name,
+			Artist:     track.Resp.Attributes.ArtistName,
+			Album:      track.Resp.Attributes.AlbumName,
+			DurationMs: track.Resp.Attributes.DurationInMillis,
+		})
+	}
+	formats := Config.PlaylistFormats
+	if len(formats) == 0 {
+		formats = []string{"m3u8"}
+	}
+	written, err := playlistfile.Write(base, p, formats)
+	for _, f := range written {
+		fmt.Println("Playlist written:", f)
+	}
+	if err != nil {
+		fmt.Println("Failed to write playlist file:", err)
+	}
+	return err
+}
+
+// syncPlaylist downloads a playlist like ripPlaylist and then brings the
+// local copy in line with the playlist's saved state: files of reordered
+// tracks are renamed to their new numbers, removed tracks are optionally
+// archived, and the changes are appended to the playlist's changelog.
+func syncPlaylist(playlistId string, token string, storefront string, mediaUserToken string) error {
+	stateDir := Config.PlaylistSyncFolder
+	if stateDir == "" {
+		stateDir = filepath.Join(saveRoot(), ".playlist-sync")
+	}
+	old, err := playlistsync.Load(stateDir, playlistId)
+	if err != nil {
+		return err
+	}
+	saved := make(map[string]string)
+	if old != nil {
+		for _, t := range old.Tracks {
+			saved[t.ID] = t.Path
+		}
+	}
+	var synced *task.Playlist
+	var changes playlistsync.Changes
+	var folder string
+	hook := func(playlist *task.Playlist, base string) error {
+		synced, folder = playlist, base
+		var current []playlistsync.Track
+		for i := range playlist.Tracks {
+			track := &playlist.Tracks[i]
+			if track.Type == "music-videos" {
+				continue
+			}
+			current = append(current, playlistsync.Track{ID: track.ID})
+			// a renumbered track keeps its file, which is renamed once
+			// the playlist has been downloaded
+			track.PrevPath = saved[track.ID]
+		}
+		changes = playlistsync.Diff(old, current)
+		fmt.Printf("Sync: %d added, %d removed, %d moved\n", len(changes.Added), len(changes.Removed), len(changes.Moved))
+		// files still at a path that a new track may plan, until they are
+		// renamed or archived below
+		syncVacated = make(map[string]bool)
+		for i := range playlist.Tracks {
+			if prev := playlist.Tracks[i].PrevPath; prev != "" {
+				syncVacated[safepath.Default.Key(prev)] = true
+			}
+		}
+		if Config.PlaylistMode != "library" && Config.PlaylistSyncArchive {
+			for _, t := range changes.Removed {
+				syncVacated[safepath.Default.Key(t.Path)] = true
+			}
+		}
+		return nil
+	}
+	defer func() { syncVacated = nil }()
+	if err := ripPlaylistWith(playlistId, token, storefront, mediaUserToken, hook); err != nil {
+		return err
+	}
+	if synced == nil {
+		return nil
+	}
+
+	// Files are only moved now that the download went through, so that the
+	// state saved below always describes the files on disk.
+	state := &playlistsync.State{ID: playlistId, Name: synced.Resp.Data[0].Attributes.Name}
+	// tracks downloaded under a temporary name, moved last
+	temps := &playlistsync.State{}
+	var placed []playlistsync.Track
+	for i := range synced.Tracks {
+		track := &synced.Tracks[i]
+		if track.Type == "music-videos" {
+			continue
+		}
+		path := cmp.Or(track.SavePath, saved[track.ID])
+		if track.SyncPath != "" && track.SavePath == syncTempPath(track.SyncPath) {
+			temps.Tracks = append(temps.Tracks, playlistsync.Track{ID: track.ID, Path: track.SavePath})
+			placed = append(placed, playlistsync.Track{ID: track.ID, Path: track.SyncPath})
+			path = track.SyncPath
+		}
+		state.Tracks = append(state.Tracks, playlistsync.Track{
+			ID:     track.ID,
+			Name:   track.Resp.Attributes.Name,
+			Artist: track.Resp.Attributes.ArtistName,
+			Path:   path,
+		})
+	}
+	sidecars := lyricsSidecars()
+	logPath := folder + ".changelog.txt"
+	if Config.PlaylistMode != "library" {
+		logPath = filepath.Join(folder, "changelog.txt")
+		if Config.PlaylistSyncArchive {
+			moved, err := playlistsync.Archive(changes.Removed, folder, "Removed", sidecars...)
+			for _, f := range moved {
+				fmt.Println("Archived:", f)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := playlistsync.Rename(old, state.Tracks, sidecars...); err != nil {
+		return err
+	}
+	for _, t := range placed {
+		if exists, _ := fileExists(t.Path); exists {
+			return fmt.Errorf("%s is still taken, new track left as %s", t.Path, syncTempPath(t.Path))
+		}
+	}
+	if err := playlistsync.Rename(temps, placed, sidecars...); err != nil {
+		return err
+	}
+	for i := range synced.Tracks {
+		if track := &synced.Tracks[i]; track.SyncPath != "" && track.SavePath == syncTempPath(track.SyncPath) {
+			track.SavePath = track.SyncPath
+			recordHistory(track.SavePath)
+			recordChecksum(track, track.SavePath, true)
+		}
+	}
+	state.Synced = time.Now()
+	if err := changes.AppendLog(logPath, state.Name, state.Synced); err != nil {
+		fmt.Println("Failed to write changelog:", err)
+	}
+	return state.Save(stateDir)
+}
+
 func writeMP4Tags(track *task.Track, lrc string) error {
 	t := &mp4tag.MP4Tags{
 		Title:      track.Resp.Attributes.Name,
@@ -1523,13 +3575,24 @@ func writeMP4Tags(track *task.Track, lrc string) error {
 		},
 		Composer:     track.Resp.Attributes.ComposerName,
 		ComposerSort: track.Resp.Attributes.ComposerName,
-		CustomGenre:  track.Resp.Attributes.GenreNames[0],
+		CustomGenre:  genrePolicy.Genre(track.Resp.Attributes.GenreNames),
 		Lyrics:       lrc,
 		TrackNumber:  int16(track.Resp.Attributes.TrackNumber),
 		DiscNumber:   int16(track.Resp.Attributes.DiscNumber),
 		Album:        track.Resp.Attributes.AlbumName,
 		AlbumSort:    track.Resp.Attributes.AlbumName,
 	}
+	if track.Resp.Attributes.Attribution != "" {
+		t.Custom["ATTRIBUTION"] = track.Resp.Attributes.Attribution
+	}
+	if short, _ := albumNotes(track); short != "" {
+		t.Description = short
+	}
+	// ©gen holds one value; multi mode lists every genre in a freeform tag
+	if genres := genrePolicy.Names(track.Resp.Attributes.GenreNames); genrePolicy.Mode == genre.Multi && len(genres) > 1 {
+		t.Custom["GENRE"] = genres[0]
+		t.OtherCustom = map[string][]string{"GENRE": genres[1:]}
+	}
 
 	if track.PreType == "albums" {
 		albumID, err := strconv.ParseUint(track.PreID, 10, 32)
@@ -1566,12 +3629,16 @@ func writeMP4Tags(track *task.Track, lrc string) error {
 		t.Date = track.AlbumData.Attributes.ReleaseDate
 		t.Copyright = track.AlbumData.Attributes.Copyright
 		t.Publisher = track.AlbumData.Attributes.RecordLabel
+		if albumID, err := strconv.ParseUint(track.AlbumData.ID, 10, 32); err == nil {
+			t.ItunesAlbumID = int32(albumID)
+		}
 	} else {
 		t.DiscTotal = int16(track.DiscTotal)
 		t.TrackTotal = int16(track.AlbumData.Attributes.TrackCount)
 		t.AlbumArtist = track.AlbumData.Attributes.ArtistName
 		t.AlbumArtistSort = track.AlbumData.Attributes.ArtistName
 		t.Custom["UPC"] = track.AlbumData.Attributes.Upc
+		t.Custom["LABEL"] = track.AlbumData.Attributes.RecordLabel
 		t.Date = track.AlbumData.Attributes.ReleaseDate
 		t.Copyright = track.AlbumData.Attributes.Copyright
 		t.Publisher = track.AlbumData.Attributes.RecordLabel
@@ -1584,17 +3651,195 @@ func writeMP4Tags(track *task.Track, lrc string) error {
 	} else {
 		t.ItunesAdvisory = mp4tag.ItunesAdvisoryNone
 	}
+	applySecondaryNames(t, track)
 
 	mp4, err := mp4tag.Open(track.SavePath)
 	if err != nil {
 		return err
 	}
-	defer mp4.Close()
-	err = mp4.Write(t, []string{})
+	// multi-valued tags are merged with the old values, so drop those first
+	err = mp4.Write(t, []string{"allothercustom"})
+	mp4.Close()
 	if err != nil {
 		return err
 	}
-	return nil
+	return writeITunesAtoms(track, track.SavePath)
+}
+
+// useSecondary reports whether names are fetched in a second language.
+func useSecondary() bool {
+	return Config.SecondaryLanguage != "" && Config.SecondaryLanguage != Config.Language
+}
+
+// fetchSecondaryNames looks up songs in secondary-language. Songs the
+// catalog does not return are missing from the map.
+func fetchSecondaryNames(storefront string, ids []string, token string) (map[string]task.Names, error) {
+	names := make(map[string]task.Names)
+	for start := 0; start < len(ids); start += 300 {
+		resp, err := ampapi.GetSongsResp(storefront, ids[start:min(start+300, len(ids))], Config.SecondaryLanguage, token)
+		if err != nil {
+			return names, err
+		}
+		for _, song := range resp.Data {
+			n := task.Names{
+				Name:         song.Attributes.Name,
+				ArtistName:   song.Attributes.ArtistName,
+				AlbumName:    song.Attributes.AlbumName,
+				ComposerName: song.Attributes.ComposerName,
+			}
+			if len(song.Relationships.Albums.Data) > 0 {
+				n.AlbumArtistName = song.Relationships.Albums.Data[0].Attributes.ArtistName
+			}
+			names[song.ID] = n
+		}
+	}
+	return names, nil
+}
+
+// loadSecondaryNames fills in the secondary-language names of the songs in
+// tracks. A failed lookup only leaves them empty.
+func loadSecondaryNames(tracks []task.Track, storefront, token string) {
+	if !useSecondary() {
+		return
+	}
+	var ids []string
+	for _, t := range tracks {
+		if t.Type == "songs" {
+			ids = append(ids, t.ID)
+		}
+	}
+	names, err := fetchSecondaryNames(storefront, ids, token)
+	if err != nil {
+		fmt.Println("Failed to get names in the secondary language:", err)
+	}
+	for i := range tracks {
+		tracks[i].Secondary = names[tracks[i].ID]
+	}
+}
+
+// applySecondaryNames places the secondary-language names as configured by
+// secondary-language-tags: in the sort tags (the default), in the display
+// tags with the primary names moved to the sort tags, or in custom tags
+// such as TITLE_EN-US.
+func applySecondaryNames(t *mp4tag.MP4Tags, track *task.Track) {
+	s := track.Secondary
+	type name struct {
+		display, sort *string
+		secondary     string
+		custom        string
+	}
+	names := []name{
+		{&t.Title, &t.TitleSort, s.Name, "TITLE"},
+		{&t.Artist, &t.ArtistSort, s.ArtistName, "ARTIST"},
+		{&t.Composer, &t.ComposerSort, s.ComposerName, "COMPOSER"},
+	}
+	// playlist entries tagged with the playlist as album keep its name
+	if track.PreType == "albums" || Config.UseSongInfoForPlaylist {
+		names = append(names,
+			name{&t.Album, &t.AlbumSort, s.AlbumName, "ALBUM"},
+			name{&t.AlbumArtist, &t.AlbumArtistSort, s.AlbumArtistName, "ALBUMARTIST"})
+	}
+	for _, n := range names {
+		if n.secondary == "" || n.secondary == *n.display {
+			continue
+		}
+		switch Config.SecondaryLanguageTags {
+		case "display":
+			*n.sort = *n.display
+			*n.display = n.secondary
+		case "custom":
+			t.Custom[n.custom+"_"+strings.ToUpper(Config.SecondaryLanguage)] = n.secondary
+		default:
+			*n.sort = n.secondary
+		}
+	}
+}
+
+// writeITunesAtoms sets the atoms go-mp4tag cannot write, or writes only on
+// some paths: media kind, song, album, artist, genre and storefront IDs,
+// classical work and movement, the long description, and the compilation
+// and gapless flags.
+// go-mp4tag rebuilds the ilst box and drops atoms it does not know, so this
+// has to run after every go-mp4tag write.
+func writeITunesAtoms(track *task.Track, path string) error {
+	atoms := []string{"stik=1"}
+	if _, err := strconv.ParseUint(track.ID, 10, 32); err == nil {
+		atoms = append(atoms, "cnID="+track.ID)
+	}
+	if id := catalogAlbumID(track); id != "" {
+		atoms = append(atoms, "plID="+id)
+	}
+	if artists := track.Resp.Relationships.Artists.Data; len(artists) > 0 {
+		if _, err := strconv.ParseUint(artists[0].ID, 10, 32); err == nil {
+			atoms = append(atoms, "atID="+artists[0].ID)
+		}
+	}
+	if id := genreID(track); id != "" {
+		atoms = append(atoms, "geID="+id)
+	}
+	if id := ampapi.StorefrontID(track.Storefront); id != 0 {
+		atoms = append(atoms, fmt.Sprintf("sfID=%d", id))
+	}
+	if attrs := track.Resp.Attributes; attrs.WorkName != "" {
+		atoms = append(atoms, "\u00a9wrk="+attrs.WorkName)
+		if attrs.MovementName != "" {
+			atoms = append(atoms, "\u00a9mvn="+attrs.MovementName)
+		}
+		if attrs.MovementNumber > 0 {
+			atoms = append(atoms, fmt.Sprintf("\u00a9mvi=%d", attrs.MovementNumber))
+		}
+		if attrs.MovementCount > 0 {
+			atoms = append(atoms, fmt.Sprintf("\u00a9mvc=%d", attrs.MovementCount))
+		}
+		atoms = append(atoms, "shwm=yes")
+	}
+	if _, long := albumNotes(track); long != "" {
+		atoms = append(atoms, "ldes="+long)
+	}
+	// album tracks are cut sample-accurately from the album master
+	if track.PreType == "albums" || Config.UseSongInfoForPlaylist {
+		atoms = append(atoms, "pgap=yes")
+		if track.AlbumData.Attributes.IsCompilation {
+			atoms = append(atoms, "cpil=yes")
+		}
+	}
+	return tools.Default.MP4Box.ITags(path, atoms)
+}
+
+// catalogAlbumID returns the catalog ID of the track's album, or "" when the
+// track is tagged as part of a playlist.
+func catalogAlbumID(track *task.Track) string {
+	id := track.AlbumData.ID
+	if track.PreType == "albums" {
+		id = track.PreID
+	} else if !Config.UseSongInfoForPlaylist {
+		return ""
+	}
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return ""
+	}
+	return id
+}
+
+// genreID returns the catalog ID of the genre written to ©gen by the genre
+// policy, from the song's genres or else the album's. A genre mapped to a
+// name the catalog does not use has no ID.
+func genreID(track *task.Track) string {
+	names := genrePolicy.Names(track.Resp.Attributes.GenreNames)
+	if len(names) == 0 {
+		return ""
+	}
+	for _, g := range track.Resp.Relationships.Genres.Data {
+		if strings.EqualFold(g.Attributes.Name, names[0]) {
+			return g.ID
+		}
+	}
+	for _, g := range track.AlbumData.Relationships.Genres.Data {
+		if strings.EqualFold(g.Attributes.Name, names[0]) {
+			return g.ID
+		}
+	}
+	return ""
 }
 
 // CLI main function - removed to avoid conflict with web server
@@ -1607,11 +3852,6 @@ func mvDownloader(adamID string, saveDir string, token string, storefront string
 		return nil
 	}
 
-	if strings.HasSuffix(saveDir, ".") {
-		saveDir = strings.ReplaceAll(saveDir, ".", "")
-	}
-	saveDir = strings.TrimSpace(saveDir)
-
 	vidPath := filepath.Join(saveDir, fmt.Sprintf("%s_vid.mp4", adamID))
 	audPath := filepath.Join(saveDir, fmt.Sprintf("%s_aud.mp4", adamID))
 	mvSaveName := fmt.Sprintf("%s (%s)", MVInfo.Data[0].Attributes.Name, adamID)
@@ -1619,7 +3859,7 @@ func mvDownloader(adamID string, saveDir string, token string, storefront string
 		mvSaveName = fmt.Sprintf("%02d. %s", track.TaskNum, MVInfo.Data[0].Attributes.Name)
 	}
 
-	mvOutPath := filepath.Join(saveDir, fmt.Sprintf("%s.mp4", forbiddenNames.ReplaceAllString(mvSaveName, "_")))
+	mvOutPath := safepath.Join(saveDir, safepath.File(mvSaveName, ".mp4"))
 
 	fmt.Println(MVInfo.Data[0].Attributes.Name)
 
@@ -1646,9 +3886,13 @@ func mvDownloader(adamID string, saveDir string, token string, storefront string
 		"tool=",
 		fmt.Sprintf("artist=%s", MVInfo.Data[0].Attributes.ArtistName),
 		fmt.Sprintf("title=%s", MVInfo.Data[0].Attributes.Name),
-		fmt.Sprintf("genre=%s", MVInfo.Data[0].Attributes.GenreNames[0]),
+		// one value only: rewriting the file with go-mp4tag for the multi
+		// mode's GENRE tag would drop the atoms MP4Box wrote
+		fmt.Sprintf("genre=%s", genrePolicy.Genre(MVInfo.Data[0].Attributes.GenreNames)),
 		fmt.Sprintf("created=%s", MVInfo.Data[0].Attributes.ReleaseDate),
 		fmt.Sprintf("ISRC=%s", MVInfo.Data[0].Attributes.Isrc),
+		fmt.Sprintf("cnID=%s", adamID),
+		"stik=6",
 	}
 
 	if MVInfo.Data[0].Attributes.ContentRating == "explicit" {
@@ -1697,7 +3941,7 @@ func mvDownloader(adamID string, saveDir string, token string, storefront string
 	var covPath string
 	if true {
 		thumbURL := MVInfo.Data[0].Attributes.Artwork.URL
-		baseThumbName := forbiddenNames.ReplaceAllString(mvSaveName, "_") + "_thumbnail"
+		baseThumbName := strings.TrimSuffix(filepath.Base(mvOutPath), ".mp4") + "_thumbnail"
 		covPath, err = writeCover(saveDir, baseThumbName, thumbURL)
 		if err != nil {
 			fmt.Println("Failed to save MV thumbnail:", err)
@@ -1706,10 +3950,8 @@ func mvDownloader(adamID string, saveDir string, token string, storefront string
 		}
 	}
 
-	tagsString := strings.Join(tags, ":")
-	muxCmd := exec.Command("MP4Box", "-itags", tagsString, "-quiet", "-add", vidPath, "-add", audPath, "-keep-utc", "-new", mvOutPath)
 	fmt.Printf("MV Remuxing...")
-	if err := muxCmd.Run(); err != nil {
+	if err := tools.Default.MP4Box.Mux(tags, mvOutPath, vidPath, audPath); err != nil {
 		fmt.Printf("MV mux failed: %v\n", err)
 		return err
 	}
@@ -7,11 +7,13 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"main/utils/ampapi"
+	"main/utils/naming"
 	"main/utils/structs"
 	"gopkg.in/yaml.v2"
 )
@@ -194,14 +196,10 @@ func (s *Server) processDownload(task *DownloadTask, storefront, id, quality str
 	s.updateTask(task.ID, "processing", 10, "Starting download...")
 
 	// Get token
-	token, err := ampapi.GetToken()
+	token, err := s.token()
 	if err != nil {
-		if s.config.AuthorizationToken != "" && s.config.AuthorizationToken != "your-authorization-token" {
-			token = strings.Replace(s.config.AuthorizationToken, "Bearer ", "", -1)
-		} else {
-			s.updateTask(task.ID, "failed", 0, "Failed to get authorization token")
-			return
-		}
+		s.updateTask(task.ID, "failed", 0, "Failed to get authorization token")
+		return
 	}
 
 	s.updateTask(task.ID, "processing", 20, "Token obtained, analyzing content...")
@@ -230,10 +228,8 @@ func (s *Server) processDownload(task *DownloadTask, storefront, id, quality str
 		}
 
 		// Set artist folder format
-		Config.ArtistFolderFormat = strings.NewReplacer(
-			"{UrlArtistName}", LimitString(urlArtistName),
-			"{ArtistId}", urlArtistID,
-		).Replace(Config.ArtistFolderFormat)
+		urlArtist = naming.Artist{ID: urlArtistID, URLName: urlArtistName}
+		defer func() { urlArtist = naming.Artist{} }()
 
 		// Get artist albums (simplified for web interface)
 		albumArgs, err := checkArtist(task.URL, token, "albums")
@@ -281,6 +277,7 @@ func (s *Server) processDownload(task *DownloadTask, storefront, id, quality str
 		return
 	}
 
+	saveHistory()
 	if err2 != nil {
 		s.updateTask(task.ID, "failed", 0, fmt.Sprintf("Download failed: %v", err2))
 		return
@@ -289,6 +286,56 @@ func (s *Server) processDownload(task *DownloadTask, storefront, id, quality str
 	s.updateTask(task.ID, "completed", 100, "Download completed successfully")
 }
 
+// token returns an Apple Music API token, falling back to the configured
+// authorization-token.
+func (s *Server) token() (string, error) {
+	token, err := ampapi.GetToken()
+	if err != nil {
+		if s.config.AuthorizationToken != "" && s.config.AuthorizationToken != "your-authorization-token" {
+			return strings.Replace(s.config.AuthorizationToken, "Bearer ", "", -1), nil
+		}
+		return "", err
+	}
+	return token, nil
+}
+
+// handleSearch searches the catalog: /api/search?q=term&type=album&offset=0.
+// type is one of the --search types and defaults to top.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	searchType := r.URL.Query().Get("type")
+	if searchType == "" {
+		searchType = "top"
+	}
+	if _, ok := searchTypes[searchType]; !ok {
+		http.Error(w, fmt.Sprintf("unknown type %q, want %s", searchType, searchTypeNames), http.StatusBadRequest)
+		return
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	token, err := s.token()
+	if err != nil {
+		http.Error(w, "Failed to get authorization token", http.StatusBadGateway)
+		return
+	}
+	items, hasNext, err := searchResults(searchType, query, token, 15, max(offset, 0))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if items == nil {
+		items = []SearchResultItem{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": items,
+		"next":    hasNext,
+	})
+}
+
 // setQualityFlags sets the global quality flags based on user selection
 func (s *Server) setQualityFlags(quality string) {
 	dl_atmos = false
@@ -349,6 +396,7 @@ func (s *Server) Start() error {
 	http.HandleFunc("/api/status", s.handleStatus)
 	http.HandleFunc("/api/tasks", s.handleTasks)
 	http.HandleFunc("/api/config", s.handleConfig)
+	http.HandleFunc("/api/search", s.handleSearch)
 
 	// Serve static files
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
@@ -376,10 +424,8 @@ func (s *Server) loadConfig() error {
 
 	if len(s.config.Storefront) != 2 {
 		s.config.Storefront = "us"
-		Config.Storefront = "us"
 	}
-
-	return nil
+	return setupConfig()
 }
 
 // HTML template for the web interface
@@ -595,6 +641,28 @@ const htmlTemplate = `
                 </button>
             </form>
             
+            <form id="searchForm">
+                <div class="form-group">
+                    <label for="searchQuery">Search:</label>
+                    <input type="text" id="searchQuery" placeholder="Album, song or artist name" required>
+                </div>
+                <div class="form-group">
+                    <select id="searchType">
+                        <option value="top">Top results</option>
+                        <option value="album">Albums</option>
+                        <option value="song">Songs</option>
+                        <option value="artist">Artists</option>
+                        <option value="playlist">Playlists</option>
+                        <option value="music-video">Music videos</option>
+                        <option value="station">Stations</option>
+                        <option value="record-label">Record labels</option>
+                        <option value="apple-curator">Curators</option>
+                    </select>
+                </div>
+                <button type="submit" class="btn">Search</button>
+                <div id="searchResults"></div>
+            </form>
+            
             <div class="loading" id="loading">
                 <div class="spinner"></div>
                 <p>Processing download...</p>
@@ -653,6 +721,42 @@ const htmlTemplate = `
             }
         });
         
+        document.getElementById('searchForm').addEventListener('submit', async function(e) {
+            e.preventDefault();
+            const query = document.getElementById('searchQuery').value;
+            const type = document.getElementById('searchType').value;
+            const list = document.getElementById('searchResults');
+            list.textContent = 'Searching...';
+            try {
+                const response = await fetch('/api/search?type=' + encodeURIComponent(type) + '&q=' + encodeURIComponent(query));
+                if (!response.ok) {
+                    list.textContent = 'Error: ' + await response.text();
+                    return;
+                }
+                const data = await response.json();
+                list.textContent = data.results.length === 0 ? 'No results found.' : '';
+                data.results.forEach(item => {
+                    const div = document.createElement('div');
+                    div.className = 'task';
+                    const details = [item.type, item.artist, item.year].filter(Boolean).join(' · ');
+                    const title = document.createElement('strong');
+                    title.textContent = item.name;
+                    const info = document.createElement('div');
+                    info.className = 'task-message';
+                    info.textContent = details + (item.audioTraits ? ' · ' + item.audioTraits.join(', ') : '');
+                    const use = document.createElement('button');
+                    use.type = 'button';
+                    use.className = 'refresh-btn';
+                    use.textContent = 'Use this URL';
+                    use.onclick = () => { document.getElementById('url').value = item.url; window.scrollTo(0, 0); };
+                    div.append(title, info, use);
+                    list.appendChild(div);
+                });
+            } catch (error) {
+                list.textContent = 'Error: ' + error.message;
+            }
+        });
+        
         function startPolling(taskId) {
             const interval = setInterval(async () => {
                 try {
@@ -27,12 +27,12 @@ func GetAlbumResp(storefront string, id string, language string, token string) (
 	req.Header.Set("Origin", "https://music.apple.com")
 	query := url.Values{}
 	query.Set("omit[resource]", "autos")
-	query.Set("include", "tracks,artists,record-labels")
-	query.Set("include[songs]", "artists")
+	query.Set("include", "tracks,artists,record-labels,genres")
+	query.Set("include[songs]", "artists,genres")
 	//query.Set("fields[artists]", "name,artwork")
 	//query.Set("fields[albums:albums]", "artistName,artwork,name,releaseDate,url")
 	//query.Set("fields[record-labels]", "name")
-	query.Set("extend", "editorialVideo,extendedAssetUrls")
+	query.Set("extend", "editorialNotes,editorialVideo,extendedAssetUrls")
 	query.Set("l", language)
 	req.URL.RawQuery = query.Encode()
 	do, err := http.DefaultClient.Do(req)
@@ -60,7 +60,7 @@ func GetAlbumResp(storefront string, id string, language string, token string) (
 			req.Header.Set("Origin", "https://music.apple.com")
 			query := req.URL.Query()
 			query.Set("omit[resource]", "autos")
-			query.Set("include", "artists")
+			query.Set("include", "artists,genres")
 			query.Set("extend", "editorialVideo,extendedAssetUrls")
 			req.URL.RawQuery = query.Encode()
 			do, err := http.DefaultClient.Do(req)
@@ -104,12 +104,12 @@ func GetAlbumRespByHref(href string, language string, token string) (*AlbumResp,
 	req.Header.Set("Origin", "https://music.apple.com")
 	query := url.Values{}
 	query.Set("omit[resource]", "autos")
-	query.Set("include", "tracks,artists,record-labels")
-	query.Set("include[songs]", "artists")
+	query.Set("include", "tracks,artists,record-labels,genres")
+	query.Set("include[songs]", "artists,genres")
 	//query.Set("fields[artists]", "name,artwork")
 	//query.Set("fields[albums:albums]", "artistName,artwork,name,releaseDate,url")
 	//query.Set("fields[record-labels]", "name")
-	query.Set("extend", "editorialVideo,extendedAssetUrls")
+	query.Set("extend", "editorialNotes,editorialVideo,extendedAssetUrls")
 	query.Set("l", language)
 	req.URL.RawQuery = query.Encode()
 	do, err := http.DefaultClient.Do(req)
@@ -137,7 +137,7 @@ func GetAlbumRespByHref(href string, language string, token string) (*AlbumResp,
 			req.Header.Set("Origin", "https://music.apple.com")
 			query := req.URL.Query()
 			query.Set("omit[resource]", "autos")
-			query.Set("include", "artists")
+			query.Set("include", "artists,genres")
 			query.Set("extend", "editorialVideo,extendedAssetUrls")
 			req.URL.RawQuery = query.Encode()
 			do, err := http.DefaultClient.Do(req)
@@ -204,6 +204,10 @@ type AlbumRespData struct {
 			Kind string `json:"kind"`
 		} `json:"playParams"`
 		IsCompilation  bool `json:"isCompilation"`
+		EditorialNotes struct {
+			Standard string `json:"standard"`
+			Short    string `json:"short"`
+		} `json:"editorialNotes"`
 		EditorialVideo struct {
 			MotionTall struct {
 				Video string `json:"video"`
@@ -238,6 +242,17 @@ type AlbumRespData struct {
 				} `json:"attributes"`
 			} `json:"data"`
 		} `json:"artists"`
+		Genres struct {
+			Href string `json:"href"`
+			Data []struct {
+				ID         string `json:"id"`
+				Type       string `json:"type"`
+				Attributes struct {
+					Name     string `json:"name"`
+					ParentID string `json:"parentId"`
+				} `json:"attributes"`
+			} `json:"data"`
+		} `json:"genres"`
 		Tracks TrackResp `json:"tracks"`
 	} `json:"relationships"`
 }
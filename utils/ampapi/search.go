@@ -12,11 +12,50 @@ type SearchResp struct {
 	Results SearchResults `json:"results"`
 }
 
-// SearchResults contains the different types of search results.
+// SearchResults contains the different types of search results. Top holds
+// the mixed "top results" of SearchTop.
 type SearchResults struct {
-	Songs   *SongResults   `json:"songs,omitempty"`
-	Albums  *AlbumResults  `json:"albums,omitempty"`
-	Artists *ArtistResults `json:"artists,omitempty"`
+	Songs         *SongResults   `json:"songs,omitempty"`
+	Albums        *AlbumResults  `json:"albums,omitempty"`
+	Artists       *ArtistResults `json:"artists,omitempty"`
+	Playlists     *ItemResults   `json:"playlists,omitempty"`
+	MusicVideos   *ItemResults   `json:"music-videos,omitempty"`
+	Stations      *ItemResults   `json:"stations,omitempty"`
+	RecordLabels  *ItemResults   `json:"record-labels,omitempty"`
+	AppleCurators *ItemResults   `json:"apple-curators,omitempty"`
+	Top           *ItemResults   `json:"top,omitempty"`
+}
+
+// ItemResults contains a list of search results of any type.
+type ItemResults struct {
+	Href string       `json:"href"`
+	Next string       `json:"next"`
+	Data []SearchItem `json:"data"`
+}
+
+// SearchItem is a search result of any type. Its attributes are those of
+// songs, albums, artists, playlists, music videos, stations, record labels
+// and curators together; the ones a type lacks stay empty.
+type SearchItem struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Href       string `json:"href"`
+	Attributes struct {
+		Name             string   `json:"name"`
+		ArtistName       string   `json:"artistName"`
+		AlbumName        string   `json:"albumName"`
+		CuratorName      string   `json:"curatorName"`
+		URL              string   `json:"url"`
+		ReleaseDate      string   `json:"releaseDate"`
+		LastModifiedDate string   `json:"lastModifiedDate"`
+		TrackCount       int      `json:"trackCount"`
+		DurationInMillis int      `json:"durationInMillis"`
+		GenreNames       []string `json:"genreNames"`
+		AudioTraits      []string `json:"audioTraits"`
+		ContentRating    string   `json:"contentRating"`
+		IsLive           bool     `json:"isLive"`
+		Kind             string   `json:"kind"` // curators: editorial, external or show
+	} `json:"attributes"`
 }
 
 // SongResults contains a list of song search results.
@@ -51,6 +90,16 @@ type ArtistResults struct {
 
 // Search performs a search query against the Apple Music API.
 func Search(storefront, term, types, language, token string, limit, offset int) (*SearchResp, error) {
+	return search(storefront, term, types, language, token, limit, offset, false)
+}
+
+// SearchTop is Search with the mixed top results of the given types in
+// Results.Top.
+func SearchTop(storefront, term, types, language, token string, limit int) (*SearchResp, error) {
+	return search(storefront, term, types, language, token, limit, 0, true)
+}
+
+func search(storefront, term, types, language, token string, limit, offset int, top bool) (*SearchResp, error) {
 	var err error
 	if token == "" {
 		token, err = GetToken()
@@ -73,6 +122,9 @@ func Search(storefront, term, types, language, token string, limit, offset int)
 	query.Set("limit", fmt.Sprintf("%d", limit))
 	query.Set("offset", fmt.Sprintf("%d", offset))
 	query.Set("l", language)
+	if top {
+		query.Set("with", "topResults")
+	}
 	req.URL.RawQuery = query.Encode()
 
 	do, err := http.DefaultClient.Do(req)
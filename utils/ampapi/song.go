@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 func GetSongResp(storefront string, id string, language string, token string) (*SongResp, error) {
@@ -51,6 +52,83 @@ func GetSongResp(storefront string, id string, language string, token string) (*
 	return obj, nil
 }
 
+// GetSongsResp fetches several songs, with their albums, in one request.
+// The catalog accepts at most 300 IDs per request.
+func GetSongsResp(storefront string, ids []string, language string, token string) (*SongResp, error) {
+	var err error
+	if token == "" {
+		token, err = GetToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/songs", storefront), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	req.Header.Set("Origin", "https://music.apple.com")
+	query := url.Values{}
+	query.Set("ids", strings.Join(ids, ","))
+	query.Set("include", "albums")
+	query.Set("l", language)
+	req.URL.RawQuery = query.Encode()
+	do, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return nil, errors.New(do.Status)
+	}
+	obj := new(SongResp)
+	err = json.NewDecoder(do.Body).Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// GetSongsByISRC looks up the catalog songs with an ISRC. Several releases
+// of a recording share one ISRC, so there may be more than one.
+func GetSongsByISRC(storefront string, isrc string, language string, token string) (*SongResp, error) {
+	var err error
+	if token == "" {
+		token, err = GetToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/songs", storefront), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	req.Header.Set("Origin", "https://music.apple.com")
+	query := url.Values{}
+	query.Set("filter[isrc]", isrc)
+	query.Set("l", language)
+	req.URL.RawQuery = query.Encode()
+	do, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return nil, errors.New(do.Status)
+	}
+	obj := new(SongResp)
+	err = json.NewDecoder(do.Body).Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
 type SongResp struct {
 	Href string         `json:"href"`
 	Next string         `json:"next"`
@@ -100,6 +178,12 @@ type SongRespData struct {
 		TrackNumber  int    `json:"trackNumber"`
 		AudioLocale  string `json:"audioLocale"`
 		ComposerName string `json:"composerName"`
+		// classical releases only
+		WorkName       string `json:"workName"`
+		MovementName   string `json:"movementName"`
+		MovementNumber int    `json:"movementNumber"`
+		MovementCount  int    `json:"movementCount"`
+		Attribution    string `json:"attribution"`
 	} `json:"attributes"`
 	Relationships struct {
 		Artists struct {
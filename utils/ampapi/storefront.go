@@ -0,0 +1,25 @@
+package ampapi
+
+import "strings"
+
+// storefrontIDs maps storefront codes to the numeric IDs iTunes writes to
+// the sfID atom.
+var storefrontIDs = map[string]int{
+	"ae": 143481, "ar": 143505, "at": 143445, "au": 143460, "be": 143446,
+	"br": 143503, "ca": 143455, "ch": 143459, "cl": 143483, "cn": 143465,
+	"co": 143501, "cz": 143489, "de": 143443, "dk": 143458, "eg": 143516,
+	"es": 143454, "fi": 143447, "fr": 143442, "gb": 143444, "gr": 143448,
+	"hk": 143463, "hu": 143482, "id": 143476, "ie": 143449, "il": 143491,
+	"in": 143467, "it": 143450, "jp": 143462, "kr": 143466, "lu": 143451,
+	"mx": 143468, "my": 143473, "nl": 143452, "no": 143457, "nz": 143461,
+	"pe": 143507, "ph": 143474, "pl": 143478, "pt": 143453, "ro": 143487,
+	"ru": 143469, "sa": 143479, "se": 143456, "sg": 143464, "th": 143475,
+	"tr": 143480, "tw": 143470, "ua": 143492, "us": 143441, "vn": 143471,
+	"za": 143472,
+}
+
+// StorefrontID returns the numeric ID of a storefront code such as "us",
+// or 0 if it is not known.
+func StorefrontID(storefront string) int {
+	return storefrontIDs[strings.ToLower(storefront)]
+}
@@ -50,6 +50,12 @@ type TrackRespData struct {
 		TrackNumber  int    `json:"trackNumber"`
 		AudioLocale  string `json:"audioLocale"`
 		ComposerName string `json:"composerName"`
+		// classical releases only
+		WorkName       string `json:"workName"`
+		MovementName   string `json:"movementName"`
+		MovementNumber int    `json:"movementNumber"`
+		MovementCount  int    `json:"movementCount"`
+		Attribution    string `json:"attribution"`
 	} `json:"attributes"`
 	Relationships struct {
 		Artists struct {
@@ -63,6 +69,17 @@ type TrackRespData struct {
 				} `json:"attributes"`
 			} `json:"data"`
 		} `json:"artists"`
+		Genres struct {
+			Href string `json:"href"`
+			Data []struct {
+				ID         string `json:"id"`
+				Type       string `json:"type"`
+				Attributes struct {
+					Name     string `json:"name"`
+					ParentID string `json:"parentId"`
+				} `json:"attributes"`
+			} `json:"data"`
+		} `json:"genres"`
 		Albums struct {
 			Href string `json:"href"`
 			Data []struct {
@@ -0,0 +1,104 @@
+// Package genre turns the catalog's genre list into tag values according to
+// a configured policy.
+package genre
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Output modes.
+const (
+	First  = "first"  // only the first genre
+	Joined = "joined" // all genres in one value, separated by Separator
+	Multi  = "multi"  // all genres as separate values
+)
+
+// Policy maps and filters genre names. Map and Blacklist keys are matched
+// without regard to case once Validate has normalized them; mapping a genre
+// to "" drops it. A genre is mapped once, never through a chain of keys.
+type Policy struct {
+	Mode      string
+	Separator string
+	Map       map[string]string
+	Blacklist []string
+	Fallback  string // used when no genre is left; may be empty
+}
+
+// Validate checks the mode, fills in the defaults and lower-cases the Map
+// and Blacklist keys. Map keys that differ only in case must agree.
+func (p *Policy) Validate() error {
+	switch p.Mode {
+	case "":
+		p.Mode = First
+	case First, Joined, Multi:
+	default:
+		return fmt.Errorf("unknown genre-mode %q (want first, joined or multi)", p.Mode)
+	}
+	if p.Separator == "" {
+		p.Separator = "; "
+	}
+	m := make(map[string]string, len(p.Map))
+	for from, to := range p.Map {
+		key := strings.ToLower(from)
+		if prev, ok := m[key]; ok && prev != to {
+			return fmt.Errorf("genre-map has conflicting entries for %q: %q and %q", from, prev, to)
+		}
+		m[key] = to
+	}
+	p.Map = m
+	blacklist := make([]string, len(p.Blacklist))
+	for i, b := range p.Blacklist {
+		blacklist[i] = strings.ToLower(b)
+	}
+	p.Blacklist = blacklist
+	return nil
+}
+
+// Names returns the mapped genres in catalog order, without blacklisted
+// ones and duplicates. When none is left it returns the fallback, or nil.
+func (p Policy) Names(names []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if p.blacklisted(name) {
+			continue
+		}
+		name = p.mapped(name)
+		key := strings.ToLower(name)
+		if name == "" || seen[key] || p.blacklisted(name) {
+			continue
+		}
+		seen[key] = true
+		out = append(out, name)
+	}
+	if len(out) == 0 && p.Fallback != "" {
+		out = []string{p.Fallback}
+	}
+	return out
+}
+
+// Genre returns the single genre value: every genre joined in Joined mode,
+// the first one otherwise, or "" when there is none.
+func (p Policy) Genre(names []string) string {
+	names = p.Names(names)
+	if len(names) == 0 {
+		return ""
+	}
+	if p.Mode == Joined {
+		return strings.Join(names, p.Separator)
+	}
+	return names[0]
+}
+
+func (p Policy) mapped(name string) string {
+	if to, ok := p.Map[strings.ToLower(name)]; ok {
+		return to
+	}
+	return name
+}
+
+func (p Policy) blacklisted(name string) bool {
+	return slices.Contains(p.Blacklist, strings.ToLower(name))
+}
@@ -0,0 +1,96 @@
+package genre
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNames(t *testing.T) {
+	p := Policy{
+		Map:       map[string]string{"HIP-HOP/RAP": "Hip Hop", "music": "", "Rock": "Alternative"},
+		Blacklist: []string{"Soundtrack", "hip hop"},
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"unchanged", []string{"Pop", "Dance"}, []string{"Pop", "Dance"}},
+		{"mapped to empty is dropped", []string{"Pop", "Music"}, []string{"Pop"}},
+		{"blacklisted", []string{"Soundtrack", "Pop"}, []string{"Pop"}},
+		{"blacklist applies to the mapped name", []string{"Hip-Hop/Rap", "Pop"}, []string{"Pop"}},
+		{"duplicates after mapping", []string{"Rock", "alternative", "Pop"}, []string{"Alternative", "Pop"}},
+		{"mapped once", []string{"Alternative"}, []string{"Alternative"}},
+		{"nothing left", []string{"Music"}, nil},
+	}
+	for _, tt := range tests {
+		if got := p.Names(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Names(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+	p.Fallback = "Other"
+	if got := p.Names([]string{"Music"}); !reflect.DeepEqual(got, []string{"Other"}) {
+		t.Errorf("Names() with fallback = %q", got)
+	}
+}
+
+func TestGenre(t *testing.T) {
+	in := []string{"Pop", "Dance", "Music"}
+	tests := []struct {
+		policy Policy
+		want   string
+	}{
+		{Policy{}, "Pop"},
+		{Policy{Mode: Joined}, "Pop; Dance; Music"},
+		{Policy{Mode: Joined, Separator: " / "}, "Pop / Dance / Music"},
+		{Policy{Mode: Multi}, "Pop"},
+		{Policy{Blacklist: []string{"pop", "dance", "music"}}, ""},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if got := tt.policy.Genre(in); got != tt.want {
+			t.Errorf("%+v.Genre() = %q, want %q", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p := Policy{Mode: "all"}
+	if err := p.Validate(); err == nil {
+		t.Error("Validate() accepted an unknown mode")
+	}
+	p = Policy{Map: map[string]string{"Rock": "Alternative", "ROCK": "Metal"}}
+	if err := p.Validate(); err == nil {
+		t.Error("Validate() accepted keys that differ only in case and disagree")
+	}
+	p = Policy{Map: map[string]string{"Rock": "Alternative", "ROCK": "Alternative"}}
+	if err := p.Validate(); err != nil {
+		t.Errorf("Validate() rejected keys that agree: %v", err)
+	}
+	blacklist := []string{"Pop"}
+	p = Policy{Blacklist: blacklist}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if blacklist[0] != "Pop" {
+		t.Error("Validate() modified the caller's blacklist")
+	}
+}
+
+// Map iteration order must not change the result.
+func TestMappingIsStable(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		p := Policy{Map: map[string]string{"rock": "Alternative", "Alternative": "Indie", "Pop": "Pop Music"}}
+		if err := p.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Names([]string{"ROCK", "pop"}); !reflect.DeepEqual(got, []string{"Alternative", "Pop Music"}) {
+			t.Fatalf("Names() = %q", got)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Package history is the store of tracks already in the library, indexed by
+// the IDs embedded in their tags, so downloads can recognise tracks that
+// were renamed or saved under another folder format.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Track is one indexed file. AlbumID and ArtistID are the iTunes catalog
+// IDs written by the downloader; zero when the file has none.
+type Track struct {
+	Path        string    `json:"path"`
+	ISRC        string    `json:"isrc,omitempty"`
+	AlbumID     int64     `json:"albumId,omitempty"`
+	ArtistID    int64     `json:"artistId,omitempty"`
+	Title       string    `json:"title"`
+	Artist      string    `json:"artist,omitempty"`
+	Album       string    `json:"album"`
+	DiscNumber  int       `json:"disc,omitempty"`
+	TrackNumber int       `json:"track,omitempty"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modTime"`
+}
+
+type file struct {
+	Version int     `json:"version"`
+	Tracks  []Track `json:"tracks"`
+}
+
+type albumTrack struct {
+	album       int64
+	disc, track int
+}
+
+// Store is safe for concurrent use.
+type Store struct {
+	path    string
+	mu      sync.Mutex
+	tracks  map[string]Track // by path
+	byISRC  map[string]string
+	byAlbum map[albumTrack]string
+	dirty   bool
+}
+
+// Open loads the store at path; a missing file gives an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	s.reset()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	for _, t := range f.Tracks {
+		s.put(t)
+	}
+	return s, nil
+}
+
+func (s *Store) reset() {
+	s.tracks = make(map[string]Track)
+	s.byISRC = make(map[string]string)
+	s.byAlbum = make(map[albumTrack]string)
+}
+
+// Path returns the file the store is saved to.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Len returns the number of indexed tracks.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tracks)
+}
+
+// Put adds or replaces the track stored for t.Path.
+func (s *Store) Put(t Track) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(t)
+	s.dirty = true
+}
+
+func (s *Store) put(t Track) {
+	t.Path = filepath.Clean(t.Path)
+	if old, ok := s.tracks[t.Path]; ok {
+		s.unindex(old)
+	}
+	s.tracks[t.Path] = t
+	if t.ISRC != "" {
+		s.byISRC[strings.ToUpper(t.ISRC)] = t.Path
+	}
+	if t.AlbumID != 0 && t.TrackNumber != 0 {
+		s.byAlbum[albumTrack{t.AlbumID, t.DiscNumber, t.TrackNumber}] = t.Path
+	}
+}
+
+// unindex drops the keys pointing at t, handing them to another copy of the
+// same track if there is one, the first by path.
+func (s *Store) unindex(t Track) {
+	isrc := strings.ToUpper(t.ISRC)
+	key := albumTrack{t.AlbumID, t.DiscNumber, t.TrackNumber}
+	ownsISRC := isrc != "" && s.byISRC[isrc] == t.Path
+	ownsKey := s.byAlbum[key] == t.Path
+	if ownsISRC {
+		delete(s.byISRC, isrc)
+	}
+	if ownsKey {
+		delete(s.byAlbum, key)
+	}
+	if !ownsISRC && !ownsKey {
+		return
+	}
+	for p, other := range s.tracks {
+		if p == t.Path {
+			continue
+		}
+		if ownsISRC && strings.ToUpper(other.ISRC) == isrc {
+			if cur, ok := s.byISRC[isrc]; !ok || p < cur {
+				s.byISRC[isrc] = p
+			}
+		}
+		if ownsKey && (albumTrack{other.AlbumID, other.DiscNumber, other.TrackNumber}) == key {
+			if cur, ok := s.byAlbum[key]; !ok || p < cur {
+				s.byAlbum[key] = p
+			}
+		}
+	}
+}
+
+// Get returns the track stored for path.
+func (s *Store) Get(path string) (Track, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tracks[filepath.Clean(path)]
+	return t, ok
+}
+
+// Remove drops the track stored for path.
+func (s *Store) Remove(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path = filepath.Clean(path)
+	if t, ok := s.tracks[path]; ok {
+		s.unindex(t)
+		delete(s.tracks, path)
+		s.dirty = true
+	}
+}
+
+// Under returns the paths of the tracks stored below root.
+func (s *Store) Under(root string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := filepath.Clean(root) + string(filepath.Separator)
+	var paths []string
+	for p := range s.tracks {
+		if strings.HasPrefix(p, prefix) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Tracks returns all stored tracks ordered by path.
+func (s *Store) Tracks() []Track {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tracks := make([]Track, 0, len(s.tracks))
+	for _, t := range s.tracks {
+		tracks = append(tracks, t)
+	}
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].Path < tracks[j].Path })
+	return tracks
+}
+
+// Find looks a track up by ISRC, then by album ID with disc and track
+// number. Either key may be empty or zero.
+func (s *Store) Find(isrc string, albumID int64, disc, track int) (Track, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if isrc != "" {
+		if p, ok := s.byISRC[strings.ToUpper(isrc)]; ok {
+			return s.tracks[p], true
+		}
+	}
+	if albumID != 0 && track != 0 {
+		if p, ok := s.byAlbum[albumTrack{albumID, disc, track}]; ok {
+			return s.tracks[p], true
+		}
+	}
+	return Track{}, false
+}
+
+// Save writes the store if it changed since it was opened or last saved.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	f := file{Version: 1, Tracks: make([]Track, 0, len(s.tracks))}
+	for _, t := range s.tracks {
+		f.Tracks = append(f.Tracks, t)
+	}
+	sort.Slice(f.Tracks, func(i, j int) bool { return f.Tracks[i].Path < f.Tracks[j].Path })
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
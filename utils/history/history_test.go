@@ -0,0 +1,131 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFind(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(Track{Path: "/music/a/01 Song.m4a", ISRC: "usaaa0000001", AlbumID: 10, DiscNumber: 1, TrackNumber: 1})
+	s.Put(Track{Path: "/music/a/02 Other.m4a", AlbumID: 10, DiscNumber: 1, TrackNumber: 2})
+	s.Put(Track{Path: "/music/b/01 Bonus.m4a", AlbumID: 10, DiscNumber: 2, TrackNumber: 1})
+	s.Put(Track{Path: "/music/c/loose.m4a"})
+
+	tests := []struct {
+		name   string
+		isrc   string
+		album  int64
+		disc   int
+		track  int
+		want   string
+		wantOK bool
+	}{
+		{"by ISRC, any case", "USAAA0000001", 0, 0, 0, "/music/a/01 Song.m4a", true},
+		{"ISRC first", "USAAA0000001", 10, 1, 2, "/music/a/01 Song.m4a", true},
+		{"unknown ISRC falls back to album", "USZZZ9999999", 10, 1, 2, "/music/a/02 Other.m4a", true},
+		{"disc matters", "", 10, 2, 1, "/music/b/01 Bonus.m4a", true},
+		{"no track number", "", 10, 1, 0, "", false},
+		{"nothing", "", 0, 0, 0, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := s.Find(tt.isrc, tt.album, tt.disc, tt.track)
+		if ok != tt.wantOK || (ok && got.Path != filepath.Clean(tt.want)) {
+			t.Errorf("%s: Find() = %q, %v; want %q, %v", tt.name, got.Path, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestPutReplacesKeys(t *testing.T) {
+	s, _ := Open(filepath.Join(t.TempDir(), "history.json"))
+	s.Put(Track{Path: "/music/song.m4a", ISRC: "OLD", AlbumID: 1, TrackNumber: 1})
+	s.Put(Track{Path: "/music/./song.m4a", ISRC: "NEW", AlbumID: 2, TrackNumber: 1})
+	if s.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", s.Len())
+	}
+	if _, ok := s.Find("OLD", 1, 0, 1); ok {
+		t.Error("old keys still find the retagged file")
+	}
+	if _, ok := s.Find("NEW", 0, 0, 0); !ok {
+		t.Error("new ISRC not indexed")
+	}
+}
+
+func TestRemoveKeepsOtherCopies(t *testing.T) {
+	s, _ := Open(filepath.Join(t.TempDir(), "history.json"))
+	s.Put(Track{Path: "/music/a.m4a", ISRC: "X", AlbumID: 1, TrackNumber: 1})
+	s.Put(Track{Path: "/music/b.m4a", ISRC: "X", AlbumID: 1, TrackNumber: 1})
+	s.Remove("/music/b.m4a")
+	if got, ok := s.Find("X", 0, 0, 0); !ok || got.Path != filepath.Clean("/music/a.m4a") {
+		t.Errorf("Find(ISRC) after removing a copy = %q, %v", got.Path, ok)
+	}
+	if got, ok := s.Find("", 1, 0, 1); !ok || got.Path != filepath.Clean("/music/a.m4a") {
+		t.Errorf("Find(album) after removing a copy = %q, %v", got.Path, ok)
+	}
+	s.Remove("/music/a.m4a")
+	if _, ok := s.Find("X", 1, 0, 1); ok {
+		t.Error("Find() after removing every copy succeeded")
+	}
+}
+
+func TestUnder(t *testing.T) {
+	s, _ := Open(filepath.Join(t.TempDir(), "history.json"))
+	for _, p := range []string{"/music/b/2.m4a", "/music/a/1.m4a", "/music/ab/3.m4a", "/other/4.m4a"} {
+		s.Put(Track{Path: p})
+	}
+	want := []string{filepath.Clean("/music/a/1.m4a")}
+	if got := s.Under("/music/a/"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Under() = %v, want %v", got, want)
+	}
+	if got := s.Under("/music"); len(got) != 3 {
+		t.Errorf("Under(/music) = %v, want 3 paths", got)
+	}
+}
+
+func TestSaveOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "history.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Save() of an unchanged store wrote a file")
+	}
+	mod := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	want := []Track{
+		{Path: filepath.Clean("/music/a.m4a"), ISRC: "X", Title: "A", Size: 10, ModTime: mod},
+		{Path: filepath.Clean("/music/b.m4a"), AlbumID: 1, TrackNumber: 2, Title: "B"},
+	}
+	for _, tr := range want {
+		s.Put(tr)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := again.Tracks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tracks() after reopening = %+v, want %+v", got, want)
+	}
+	if _, ok := again.Find("", 1, 0, 2); !ok {
+		t.Error("reopened store lost its album index")
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Error("Open() accepted a broken file")
+	}
+}
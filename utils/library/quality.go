@@ -0,0 +1,145 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// Quality describes the audio stream of a file or of a manifest variant.
+// Codec is the sample entry type: "alac", "ec-3", "ac-3" or "mp4a".
+// Bitrate is in kbps and may be zero when the file does not record it.
+type Quality struct {
+	Codec      string
+	SampleRate int
+	BitDepth   int
+	Bitrate    int
+}
+
+func (q Quality) String() string {
+	switch q.Codec {
+	case "alac":
+		return fmt.Sprintf("ALAC %d-bit/%gkHz", q.BitDepth, float64(q.SampleRate)/1000)
+	case "ec-3", "ac-3", "mp4a":
+		name := map[string]string{"ec-3": "E-AC-3", "ac-3": "AC-3", "mp4a": "AAC"}[q.Codec]
+		if q.Bitrate == 0 {
+			return name
+		}
+		return fmt.Sprintf("%s %dkbps", name, q.Bitrate)
+	}
+	return q.Codec
+}
+
+// Better reports whether q is an upgrade over than. AAC is the lowest
+// codec; ALAC and the Dolby codecs are not ranked against each other, so a
+// library downloaded in one of those modes is never switched to another.
+// Within a codec, a higher sample rate, bit depth or bitrate is better.
+func (q Quality) Better(than Quality) bool {
+	if q.Codec != than.Codec {
+		return than.Codec == "mp4a" && q.Codec != ""
+	}
+	if q.SampleRate != than.SampleRate {
+		return q.SampleRate > than.SampleRate
+	}
+	if q.BitDepth != than.BitDepth {
+		return q.BitDepth > than.BitDepth
+	}
+	return q.Bitrate > than.Bitrate
+}
+
+// ParseManifestQuality reads the quality extractMedia reports for a stream
+// variant in codec: "24B-192.0kHz" for ALAC and "768 Kbps" for the others.
+// Atmos variants are named after their bitrate plus 2000, e.g. 2768.
+func ParseManifestQuality(codec, s string) Quality {
+	q := Quality{Codec: codec}
+	switch codec {
+	case "alac":
+		var khz float64
+		fmt.Sscanf(s, "%dB-%fkHz", &q.BitDepth, &khz)
+		q.SampleRate = int(khz*1000 + 0.5)
+	case "ec-3":
+		fmt.Sscanf(s, "%d Kbps", &q.Bitrate)
+		if q.Bitrate > 2000 {
+			q.Bitrate -= 2000
+		}
+	default:
+		fmt.Sscanf(s, "%d Kbps", &q.Bitrate)
+	}
+	return q
+}
+
+// ReadQuality reads the codec and stream parameters of an .m4a file.
+func ReadQuality(path string) (Quality, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Quality{}, err
+	}
+	defer f.Close()
+	parsed, err := mp4.DecodeFile(f, mp4.WithDecodeMode(mp4.DecModeLazyMdat))
+	if err != nil {
+		return Quality{}, err
+	}
+	if parsed.Moov == nil || parsed.Moov.Trak == nil {
+		return Quality{}, errors.New("no audio track")
+	}
+	stbl := parsed.Moov.Trak.Mdia.Minf.Stbl
+	if stbl == nil || stbl.Stsd == nil || len(stbl.Stsd.Children) == 0 {
+		return Quality{}, errors.New("no sample description")
+	}
+	entry := stbl.Stsd.Children[0]
+	if entry.Type() == "alac" {
+		return alacQuality(entry)
+	}
+	audio, ok := entry.(*mp4.AudioSampleEntryBox)
+	if !ok {
+		return Quality{}, fmt.Errorf("unsupported sample entry %q", entry.Type())
+	}
+	q := Quality{
+		Codec:      audio.Type(),
+		SampleRate: int(audio.SampleRate),
+		BitDepth:   int(audio.SampleSize),
+	}
+	switch {
+	case audio.Dec3 != nil:
+		q.Bitrate = int(audio.Dec3.DataRate)
+	case audio.Esds != nil && audio.Esds.DecConfigDescriptor != nil:
+		q.Bitrate = int(audio.Esds.DecConfigDescriptor.AvgBitrate / 1000)
+	case audio.Btrt != nil:
+		q.Bitrate = int(audio.Btrt.AvgBitrate / 1000)
+	}
+	return q, nil
+}
+
+// alacQuality reads the magic cookie of an ALAC sample entry, which mp4ff
+// does not decode. The 16-bit rate of the sample entry cannot hold hi-res
+// rates, so the cookie is the only reliable source.
+func alacQuality(entry mp4.Box) (Quality, error) {
+	var buf bytes.Buffer
+	if err := entry.Encode(&buf); err != nil {
+		return Quality{}, err
+	}
+	data := buf.Bytes()
+	// Box header (8) and audio sample entry fields (28), then child boxes.
+	for pos := 36; pos+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		if size < 8 || pos+size > len(data) {
+			break
+		}
+		if string(data[pos+4:pos+8]) == "alac" && size >= 36 {
+			// Full box header (12), then the 24-byte ALACSpecificConfig.
+			cookie := data[pos+12 : pos+36]
+			return Quality{
+				Codec:      "alac",
+				BitDepth:   int(cookie[5]),
+				Bitrate:    int(binary.BigEndian.Uint32(cookie[16:]) / 1000),
+				SampleRate: int(binary.BigEndian.Uint32(cookie[20:])),
+			}, nil
+		}
+		pos += size
+	}
+	return Quality{}, errors.New("alac sample entry has no magic cookie")
+}
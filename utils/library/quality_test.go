@@ -0,0 +1,121 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// alacCookie encodes an ALACSpecificConfig with depth, bitrate (bps) and rate.
+func alacCookie(depth byte, bitrate, rate uint32) []byte {
+	var cookie bytes.Buffer
+	binary.Write(&cookie, binary.BigEndian, uint32(4096)) // frame length
+	cookie.Write([]byte{0, depth, 40, 10, 14, 2})         // version, depth, pb, mb, kb, channels
+	binary.Write(&cookie, binary.BigEndian, uint16(255))  // max run
+	binary.Write(&cookie, binary.BigEndian, uint32(0))    // max frame bytes
+	binary.Write(&cookie, binary.BigEndian, bitrate)
+	binary.Write(&cookie, binary.BigEndian, rate)
+	return cookie.Bytes()
+}
+
+// alacEntry encodes an ALAC sample entry holding cookie, if any. The entry
+// itself claims 16-bit/44.1 kHz, as files with hi-res rates do, since its
+// 16-bit rate field cannot hold them.
+func alacEntry(cookie []byte) []byte {
+	size := 8 + 28
+	if cookie != nil {
+		size += 12 + len(cookie)
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(size))
+	b.WriteString("alac")
+	b.Write(make([]byte, 6))                              // reserved
+	binary.Write(&b, binary.BigEndian, uint16(1))         // data reference index
+	b.Write(make([]byte, 8))                              // version, revision, vendor
+	binary.Write(&b, binary.BigEndian, uint16(2))         // channels
+	binary.Write(&b, binary.BigEndian, uint16(16))        // sample size
+	b.Write(make([]byte, 4))                              // pre-defined, reserved
+	binary.Write(&b, binary.BigEndian, uint32(44100<<16)) // 16.16 sample rate
+	if cookie != nil {
+		binary.Write(&b, binary.BigEndian, uint32(12+len(cookie)))
+		b.WriteString("alac")
+		b.Write(make([]byte, 4)) // version and flags
+		b.Write(cookie)
+	}
+	return b.Bytes()
+}
+
+func TestAlacQuality(t *testing.T) {
+	box, err := mp4.DecodeBox(0, bytes.NewReader(alacEntry(alacCookie(24, 2304000, 192000))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := alacQuality(box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Quality{Codec: "alac", SampleRate: 192000, BitDepth: 24, Bitrate: 2304}
+	if got != want {
+		t.Errorf("alacQuality() = %+v, want %+v", got, want)
+	}
+	if s := got.String(); s != "ALAC 24-bit/192kHz" {
+		t.Errorf("String() = %q", s)
+	}
+
+	box, err = mp4.DecodeBox(0, bytes.NewReader(alacEntry(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alacQuality(box); err == nil {
+		t.Error("alacQuality() of an entry without a cookie succeeded")
+	}
+}
+
+func TestBetter(t *testing.T) {
+	aac := Quality{Codec: "mp4a", Bitrate: 256}
+	cd := Quality{Codec: "alac", SampleRate: 44100, BitDepth: 16}
+	tests := []struct {
+		name    string
+		q, than Quality
+		want    bool
+	}{
+		{"AAC to ALAC", cd, aac, true},
+		{"ALAC to AAC", aac, cd, false},
+		{"AAC to E-AC-3", Quality{Codec: "ec-3", Bitrate: 768}, aac, true},
+		{"higher rate", Quality{Codec: "alac", SampleRate: 48000, BitDepth: 16}, cd, true},
+		{"higher depth", Quality{Codec: "alac", SampleRate: 44100, BitDepth: 24}, cd, true},
+		{"rate before depth", Quality{Codec: "alac", SampleRate: 48000, BitDepth: 16}, Quality{Codec: "alac", SampleRate: 44100, BitDepth: 24}, true},
+		{"same", cd, cd, false},
+		{"higher bitrate", Quality{Codec: "ec-3", Bitrate: 768}, Quality{Codec: "ec-3", Bitrate: 640}, true},
+		{"ALAC over E-AC-3", Quality{Codec: "alac", SampleRate: 192000, BitDepth: 24}, Quality{Codec: "ec-3", Bitrate: 768}, false},
+		{"E-AC-3 over ALAC", Quality{Codec: "ec-3", Bitrate: 768}, cd, false},
+		{"unknown codec", Quality{}, aac, false},
+	}
+	for _, tt := range tests {
+		if got := tt.q.Better(tt.than); got != tt.want {
+			t.Errorf("%s: %v.Better(%v) = %v, want %v", tt.name, tt.q, tt.than, got, tt.want)
+		}
+	}
+}
+
+func TestParseManifestQuality(t *testing.T) {
+	tests := []struct {
+		codec, in string
+		want      Quality
+	}{
+		{"alac", "24B-192.0kHz", Quality{Codec: "alac", BitDepth: 24, SampleRate: 192000}},
+		{"alac", "16B-44.1kHz", Quality{Codec: "alac", BitDepth: 16, SampleRate: 44100}},
+		{"alac", "24B-88.2kHz", Quality{Codec: "alac", BitDepth: 24, SampleRate: 88200}},
+		{"ec-3", "2768 Kbps", Quality{Codec: "ec-3", Bitrate: 768}},
+		{"ec-3", "640 Kbps", Quality{Codec: "ec-3", Bitrate: 640}},
+		{"mp4a", "256 Kbps", Quality{Codec: "mp4a", Bitrate: 256}},
+		{"alac", "", Quality{Codec: "alac"}},
+	}
+	for _, tt := range tests {
+		if got := ParseManifestQuality(tt.codec, tt.in); got != tt.want {
+			t.Errorf("ParseManifestQuality(%q, %q) = %+v, want %+v", tt.codec, tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Package library works on files already downloaded: it reads their tags
+// into the history store and inspects their audio stream.
+package library
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"main/utils/history"
+
+	"github.com/zhaarey/go-mp4tag"
+)
+
+// ScanResult counts what a scan did.
+type ScanResult struct {
+	Scanned   int // .m4a files found
+	Updated   int // files (re)read because they are new or changed
+	Unchanged int
+	Removed   int // index entries under root whose file is gone
+	Failed    int
+}
+
+// ReadTrack reads the identifying tags of an .m4a file.
+func ReadTrack(path string) (history.Track, error) {
+	mp4, err := mp4tag.Open(path)
+	if err != nil {
+		return history.Track{}, err
+	}
+	defer mp4.Close()
+	tags, err := mp4.Read()
+	if err != nil {
+		return history.Track{}, err
+	}
+	return history.Track{
+		Path:        path,
+		ISRC:        tags.Custom["ISRC"],
+		AlbumID:     int64(tags.ItunesAlbumID),
+		ArtistID:    int64(tags.ItunesArtistID),
+		Title:       tags.Title,
+		Artist:      tags.Artist,
+		Album:       tags.Album,
+		DiscNumber:  int(tags.DiscNumber),
+		TrackNumber: int(tags.TrackNumber),
+	}, nil
+}
+
+// ReadLyrics returns the lyrics embedded in an .m4a file.
+func ReadLyrics(path string) (string, error) {
+	mp4, err := mp4tag.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer mp4.Close()
+	tags, err := mp4.Read()
+	if err != nil {
+		return "", err
+	}
+	return tags.Lyrics, nil
+}
+
+// EmbedLyrics replaces the lyrics of an .m4a file, keeping its other tags.
+func EmbedLyrics(path, lyrics string) error {
+	mp4, err := mp4tag.Open(path)
+	if err != nil {
+		return err
+	}
+	defer mp4.Close()
+	return mp4.Write(&mp4tag.MP4Tags{Lyrics: lyrics}, []string{})
+}
+
+// CopyTags copies the tags and cover art of src onto dst, for a file that
+// replaces src.
+func CopyTags(src, dst string) error {
+	from, err := mp4tag.Open(src)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	tags, err := from.Read()
+	if err != nil {
+		return err
+	}
+	to, err := mp4tag.Open(dst)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+	return to.Write(tags, []string{})
+}
+
+// Scan indexes every .m4a file below root. Files whose size and
+// modification time match the index are not read again, and index entries
+// below root whose file no longer exists are dropped. onError is called for
+// files that cannot be read; it may be nil.
+func Scan(root string, store *history.Store, onError func(path string, err error)) (ScanResult, error) {
+	var res ScanResult
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return res, err
+	}
+	seen := make(map[string]bool)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".m4a") {
+			return nil
+		}
+		res.Scanned++
+		path = filepath.Clean(path)
+		seen[path] = true
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if t, ok := store.Get(path); ok && t.Size == info.Size() && t.ModTime.Equal(info.ModTime()) {
+			res.Unchanged++
+			return nil
+		}
+		t, err := ReadTrack(path)
+		if err != nil {
+			res.Failed++
+			if onError != nil {
+				onError(path, err)
+			}
+			return nil
+		}
+		t.Size = info.Size()
+		t.ModTime = info.ModTime()
+		store.Put(t)
+		res.Updated++
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	for _, path := range store.Under(root) {
+		if !seen[path] {
+			store.Remove(path)
+			res.Removed++
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,147 @@
+package lyrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Layers of a lyrics document.
+const (
+	Original        = "original"
+	Translation     = "translation"
+	Transliteration = "transliteration"
+)
+
+// Layout selects the layers written and how. With no Layers, formats keep
+// their default content.
+type Layout struct {
+	Layers   []string // in output order
+	Separate bool     // one file per layer instead of interleaved lines
+	Language string   // preferred translation language, e.g. "en" or "zh-Hant"
+}
+
+// File is one rendered lyrics file. Suffix is empty for the main file and
+// the layer name for the other files of a separate layout. The main file
+// holds the first layer the document has, which need not be the first
+// layer asked for.
+type File struct {
+	Suffix string
+	Data   string
+}
+
+// RenderLayout converts a TTML document to format, laid out as layout asks.
+// Layers missing from the document produce no file.
+func RenderLayout(ttml, format string, layout Layout) ([]File, error) {
+	l, err := Parse(ttml)
+	if err != nil {
+		return nil, err
+	}
+	return l.Render(format, layout)
+}
+
+// Render writes l in format, laid out as layout asks.
+func (l *Lyrics) Render(format string, layout Layout) ([]File, error) {
+	w, ok := Lookup(format)
+	if !ok {
+		return nil, fmt.Errorf("unknown lyrics format %q", format)
+	}
+	if len(layout.Layers) == 0 || format == "ttml" {
+		data, err := w.Write(l)
+		if err != nil {
+			return nil, err
+		}
+		return []File{{Data: data}}, nil
+	}
+	if !layout.Separate {
+		data, err := w.Write(l.Interleave(layout.Layers, layout.Language))
+		if err != nil {
+			return nil, err
+		}
+		return []File{{Data: data}}, nil
+	}
+	var files []File
+	for _, layer := range layout.Layers {
+		layered := l.Interleave([]string{layer}, layout.Language)
+		if len(layered.Lines) == 0 {
+			continue
+		}
+		data, err := w.Write(layered)
+		if err != nil {
+			return nil, err
+		}
+		f := File{Data: data}
+		if len(files) > 0 {
+			f.Suffix = layer
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// Interleave returns a copy of l with each line replaced by its versions in
+// the given layers, in order. Lines lack the layers they have no version
+// in. The copy has no translations or transliterations left, so every
+// format writes its lines as they are.
+func (l *Lyrics) Interleave(layers []string, language string) *Lyrics {
+	out := *l
+	out.Lines = nil
+	for _, line := range l.Lines {
+		for _, layer := range layers {
+			var t *Text
+			switch layer {
+			case Original:
+				t = &Text{Text: line.Text, Words: line.Words}
+			case Translation:
+				t = pick(line.Translations, language)
+			case Transliteration:
+				t = pick(line.Transliterations, "")
+			}
+			if t == nil {
+				continue
+			}
+			version := Line{
+				Begin: line.Begin,
+				End:   line.End,
+				Key:   line.Key,
+				Agent: line.Agent,
+				Part:  line.Part,
+				Text:  t.Text,
+				Words: t.Words,
+			}
+			if layer == Original {
+				version.Background = line.Background
+			}
+			out.Lines = append(out.Lines, version)
+		}
+	}
+	return &out
+}
+
+// pick returns the text in language, matching "en" to "en-US" as well, or
+// else the first one.
+func pick(texts []Text, language string) *Text {
+	if len(texts) == 0 {
+		return nil
+	}
+	for _, exact := range []bool{true, false} {
+		for i, t := range texts {
+			if language != "" && matchLanguage(t.Language, language, exact) {
+				return &texts[i]
+			}
+		}
+	}
+	return &texts[0]
+}
+
+func matchLanguage(tag, want string, exact bool) bool {
+	if exact {
+		return strings.EqualFold(tag, want)
+	}
+	base := func(s string) string { return strings.ToLower(strings.SplitN(s, "-", 2)[0]) }
+	return base(tag) == base(want)
+}
+
+// IsLayer reports whether name is a known layer.
+func IsLayer(name string) bool {
+	return name == Original || name == Translation || name == Transliteration
+}
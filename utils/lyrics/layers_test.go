@@ -0,0 +1,122 @@
+package lyrics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRenderSeparate(t *testing.T) {
+	tests := []struct {
+		fixture string
+		layers  []string
+		want    []string // suffixes of the files produced
+	}{
+		{"translated.ttml", []string{Original, Translation, Transliteration}, []string{"", Translation, Transliteration}},
+		{"translated.ttml", []string{Translation, Original}, []string{"", Original}},
+		// without a translation, the original is the main file
+		{"line.ttml", []string{Translation, Original}, []string{""}},
+		{"line.ttml", []string{Translation, Transliteration, Original}, []string{""}},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := RenderLayout(string(data), "lrc", Layout{Layers: tt.layers, Separate: true})
+		if err != nil {
+			t.Fatalf("%s %v: %v", tt.fixture, tt.layers, err)
+		}
+		var got []string
+		for _, f := range files {
+			if f.Data == "" {
+				t.Errorf("%s %v: file %q is empty", tt.fixture, tt.layers, f.Suffix)
+			}
+			got = append(got, f.Suffix)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %v: suffixes = %q, want %q", tt.fixture, tt.layers, got, tt.want)
+		}
+	}
+}
+
+func TestRenderInterleaved(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "translated.ttml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := RenderLayout(string(data), "txt", Layout{Layers: []string{Original, Translation}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "おはようございます\nGood morning\nまた 明日\nSee you tomorrow\nOK\n"
+	if len(files) != 1 || files[0].Suffix != "" || files[0].Data != want {
+		t.Errorf("RenderLayout() = %q, want one main file %q", files, want)
+	}
+	if _, err := RenderLayout(string(data), "doc", Layout{}); err == nil {
+		t.Error("RenderLayout() accepted an unknown format")
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	sec := func(n float64) time.Duration { return time.Duration(n * float64(time.Second)) }
+	l := &Lyrics{Timing: TimingLine, Lines: []Line{{
+		Begin:      sec(1),
+		End:        sec(2),
+		Key:        "L1",
+		Agent:      "v1",
+		Text:       "Bonjour",
+		Background: &Text{Text: "(oui)"},
+		Translations: []Text{
+			{Language: "en-US", Text: "Hello"},
+			{Language: "de", Text: "Hallo"},
+		},
+	}, {
+		Begin: sec(3),
+		End:   sec(4),
+		Key:   "L2",
+		Text:  "Merci",
+	}}}
+	got := l.Interleave([]string{Translation, Original, Transliteration}, "de")
+	want := []Line{
+		{Begin: sec(1), End: sec(2), Key: "L1", Agent: "v1", Text: "Hallo"},
+		{Begin: sec(1), End: sec(2), Key: "L1", Agent: "v1", Text: "Bonjour", Background: &Text{Text: "(oui)"}},
+		{Begin: sec(3), End: sec(4), Key: "L2", Text: "Merci"},
+	}
+	if !reflect.DeepEqual(got.Lines, want) {
+		t.Errorf("Interleave() lines = %+v, want %+v", got.Lines, want)
+	}
+	if len(l.Lines) != 2 || len(l.Lines[0].Translations) != 2 {
+		t.Error("Interleave() changed the lyrics it copied")
+	}
+	if got := l.Interleave([]string{Transliteration}, ""); len(got.Lines) != 0 {
+		t.Errorf("Interleave(transliteration) = %+v, want no lines", got.Lines)
+	}
+}
+
+func TestPick(t *testing.T) {
+	texts := []Text{{Language: "en-GB"}, {Language: "zh-Hant"}, {Language: "en"}}
+	tests := []struct {
+		language string
+		want     string
+	}{
+		{"en", "en"},           // exact match before the base-language one
+		{"EN-gb", "en-GB"},     // case-insensitive
+		{"en-US", "en-GB"},     // base language
+		{"zh-Hans", "zh-Hant"}, // base language
+		{"zh", "zh-Hant"},
+		{"fr", "en-GB"}, // no match: first
+		{"", "en-GB"},
+	}
+	for _, tt := range tests {
+		got := pick(texts, tt.language)
+		if got == nil || got.Language != tt.want {
+			t.Errorf("pick(%q) = %+v, want %q", tt.language, got, tt.want)
+		}
+	}
+	if got := pick(nil, "en"); got != nil {
+		t.Errorf("pick(nil) = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,105 @@
+package lyrics
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	lrcStamp = regexp.MustCompile(`^\[(\d+):(\d+(?:\.\d+)?)\]`)
+	lrcWord  = regexp.MustCompile(`<(\d+):(\d+(?:\.\d+)?)>`)
+	lrcTag   = regexp.MustCompile(`^\[[a-zA-Z#]+:.*\]$`)
+)
+
+// ParseLRC reads an LRC document, plain or enhanced. A line may carry
+// several timestamps; lines are sorted by time. A document without any
+// timestamp is read as unsynchronised text.
+func ParseLRC(lrc string) (*Lyrics, error) {
+	l := &Lyrics{Timing: TimingLine}
+	var plain []string
+	for _, raw := range strings.Split(strings.ReplaceAll(lrc, "\r\n", "\n"), "\n") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" || lrcTag.MatchString(raw) {
+			continue
+		}
+		var begins []time.Duration
+		for {
+			m := lrcStamp.FindStringSubmatch(raw)
+			if m == nil {
+				break
+			}
+			begin, err := parseTime(m[1] + ":" + m[2])
+			if err != nil {
+				return nil, err
+			}
+			begins = append(begins, begin)
+			raw = raw[len(m[0]):]
+		}
+		if len(begins) == 0 {
+			plain = append(plain, raw)
+			continue
+		}
+		words, err := lrcWords(raw)
+		if err != nil {
+			return nil, err
+		}
+		text := collapse(lrcWord.ReplaceAllString(raw, ""))
+		if len(words) > 0 {
+			l.Timing = TimingWord
+			text = joinWords(words)
+		}
+		if text == "" {
+			continue
+		}
+		for _, begin := range begins {
+			line := Line{Begin: begin, Text: text}
+			if len(words) > 0 {
+				// a line repeated at several times is word-timed once
+				line.Words = append([]Word(nil), words...)
+				line.End = words[len(words)-1].End
+			}
+			l.Lines = append(l.Lines, line)
+		}
+	}
+	if len(l.Lines) == 0 {
+		l.Timing = TimingNone
+		for _, text := range plain {
+			l.Lines = append(l.Lines, Line{Text: text})
+		}
+		return l, nil
+	}
+	sort.SliceStable(l.Lines, func(i, j int) bool { return l.Lines[i].Begin < l.Lines[j].Begin })
+	return l, nil
+}
+
+// lrcWords splits an enhanced LRC line into words. Each word ends where
+// the next stamp begins; a trailing stamp ends the last word.
+func lrcWords(s string) ([]Word, error) {
+	stamps := lrcWord.FindAllStringSubmatchIndex(s, -1)
+	var words []Word
+	for i, m := range stamps {
+		begin, err := parseTime(s[m[2]:m[3]] + ":" + s[m[4]:m[5]])
+		if err != nil {
+			return nil, err
+		}
+		if n := len(words); n > 0 && words[n-1].End == 0 {
+			words[n-1].End = begin
+		}
+		end := len(s)
+		if i+1 < len(stamps) {
+			end = stamps[i+1][0]
+		}
+		if text := s[m[1]:end]; strings.TrimSpace(text) != "" {
+			words = append(words, Word{Begin: begin, Text: text})
+		}
+	}
+	if n := len(words); n > 0 {
+		words[n-1].Text = strings.TrimRight(words[n-1].Text, " ")
+		if words[n-1].End == 0 {
+			words[n-1].End = words[n-1].Begin
+		}
+	}
+	return words, nil
+}
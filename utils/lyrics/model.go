@@ -0,0 +1,399 @@
+package lyrics
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timing values of the itunes:timing attribute.
+const (
+	TimingNone = "None" // unsynchronised
+	TimingLine = "Line"
+	TimingWord = "Word"
+)
+
+// Lyrics is a parsed TTML document. Source keeps the TTML it was parsed
+// from, for the formats that copy it.
+type Lyrics struct {
+	Timing      string
+	Language    string
+	Songwriters []string
+	Agents      []Agent
+	Lines       []Line
+	Source      string
+	File        string // file the lyrics were read from, if any
+}
+
+// Agent is a singer of a duet, referenced by Line.Agent. Apple uses v1 and
+// v2 for the singers and v1000 for lines they sing together.
+type Agent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"` // person, group or other
+	Name string `json:"name,omitempty"`
+}
+
+// Line is one lyric line. Words is set for word-timed lyrics only.
+type Line struct {
+	Begin            time.Duration
+	End              time.Duration
+	Key              string // itunes:key, which translations refer to
+	Agent            string
+	Part             string // song part of the line, e.g. "Verse" or "Chorus"
+	Text             string
+	Words            []Word
+	Background       *Text // background vocals
+	Translations     []Text
+	Transliterations []Text
+}
+
+// Text is a background-vocal, translated or transliterated version of a
+// line. Words is set when it has its own word timing.
+type Text struct {
+	Language string
+	Text     string
+	Words    []Word
+}
+
+// Word is a timed syllable or word. Text includes the space that follows
+// it, if any, so the texts of a line's words join into the line.
+type Word struct {
+	Begin time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Synced reports whether the lyrics have timestamps.
+func (l *Lyrics) Synced() bool {
+	return l.Timing != TimingNone && len(l.Lines) > 0
+}
+
+// AgentName returns the name of a singer, or its ID when the document does
+// not name it.
+func (l *Lyrics) AgentName(id string) string {
+	for _, a := range l.Agents {
+		if a.ID == id && a.Name != "" {
+			return a.Name
+		}
+	}
+	return id
+}
+
+type ttmlDoc struct {
+	XMLName xml.Name
+	Timing  string `xml:"timing,attr"`
+	Lang    string `xml:"lang,attr"`
+	Agents  []struct {
+		ID    string   `xml:"id,attr"`
+		Type  string   `xml:"type,attr"`
+		Names []string `xml:"name"`
+	} `xml:"head>metadata>agent"`
+	ITunes struct {
+		Songwriters      []string  `xml:"songwriters>songwriter"`
+		Translations     []textSet `xml:"translations>translation"`
+		Transliterations []textSet `xml:"transliterations>transliteration"`
+	} `xml:"head>metadata>iTunesMetadata"`
+	Divs []struct {
+		SongPart string `xml:"songPart,attr"`
+		Lines    []span `xml:"p"`
+	} `xml:"body>div"`
+}
+
+type textSet struct {
+	Lang  string `xml:"lang,attr"`
+	Texts []span `xml:"text"`
+}
+
+// span is a p, span or text element with its mixed content in document
+// order: strings and *span children.
+type span struct {
+	Begin, End, Key, Agent, Role, For, Text string
+	Content                                 []interface{}
+}
+
+func (s *span) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, a := range start.Attr {
+		switch a.Name.Local {
+		case "begin":
+			s.Begin = a.Value
+		case "end":
+			s.End = a.Value
+		case "key":
+			s.Key = a.Value
+		case "agent":
+			s.Agent = a.Value
+		case "role":
+			s.Role = a.Value
+		case "for":
+			s.For = a.Value
+		case "text":
+			s.Text = a.Value
+		}
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			s.Content = append(s.Content, string(t))
+		case xml.StartElement:
+			child := new(span)
+			if err := child.UnmarshalXML(d, t); err != nil {
+				return err
+			}
+			s.Content = append(s.Content, child)
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// plain returns the text of s without background vocals.
+func (s *span) plain() string {
+	if s.Text != "" {
+		return s.Text
+	}
+	var b strings.Builder
+	for _, c := range s.Content {
+		switch c := c.(type) {
+		case string:
+			b.WriteString(c)
+		case *span:
+			if c.Role != "x-bg" {
+				b.WriteString(c.plain())
+			}
+		}
+	}
+	return b.String()
+}
+
+// background returns the background-vocal span of s, if any.
+func (s *span) background() *span {
+	for _, c := range s.Content {
+		if c, ok := c.(*span); ok && c.Role == "x-bg" {
+			return c
+		}
+	}
+	return nil
+}
+
+// words returns the timed spans of s, outside background vocals.
+func (s *span) words() ([]Word, error) {
+	var words []Word
+	var walk func(s *span) error
+	walk = func(s *span) error {
+		for _, c := range s.Content {
+			switch c := c.(type) {
+			case string:
+				if len(words) > 0 && strings.TrimSpace(c) == "" && c != "" {
+					words[len(words)-1].Text += " "
+				}
+			case *span:
+				if c.Role == "x-bg" {
+					continue
+				}
+				if c.Begin == "" {
+					if err := walk(c); err != nil {
+						return err
+					}
+					continue
+				}
+				w := Word{Text: c.plain()}
+				var err error
+				if w.Begin, err = parseTime(c.Begin); err != nil {
+					return err
+				}
+				if w.End, err = parseTime(c.End); err != nil {
+					return err
+				}
+				words = append(words, w)
+			}
+		}
+		return nil
+	}
+	err := walk(s)
+	if n := len(words); n > 0 {
+		words[n-1].Text = strings.TrimRight(words[n-1].Text, " ")
+	}
+	return words, err
+}
+
+// text converts s to a Text, with word timing if it has timed spans.
+func (s *span) text(lang string) (*Text, error) {
+	words, err := s.words()
+	if err != nil {
+		return nil, err
+	}
+	t := &Text{Language: lang, Words: words, Text: collapse(s.plain())}
+	if len(words) > 0 {
+		t.Text = joinWords(words)
+	}
+	return t, nil
+}
+
+// Parse reads an Apple Music TTML document.
+func Parse(ttml string) (*Lyrics, error) {
+	var doc ttmlDoc
+	if err := xml.Unmarshal([]byte(ttml), &doc); err != nil {
+		return nil, err
+	}
+	if doc.XMLName.Local != "tt" {
+		return nil, errors.New("not a TTML document")
+	}
+	l := &Lyrics{
+		Timing:      doc.Timing,
+		Language:    doc.Lang,
+		Songwriters: doc.ITunes.Songwriters,
+		Source:      ttml,
+	}
+	if l.Timing == "" {
+		l.Timing = TimingLine
+	}
+	for _, a := range doc.Agents {
+		agent := Agent{ID: a.ID, Type: a.Type}
+		if len(a.Names) > 0 {
+			agent.Name = strings.TrimSpace(a.Names[0])
+		}
+		l.Agents = append(l.Agents, agent)
+	}
+	translations := keyed(doc.ITunes.Translations)
+	transliterations := keyed(doc.ITunes.Transliterations)
+	for _, div := range doc.Divs {
+		for i := range div.Lines {
+			p := &div.Lines[i]
+			line, err := parseLine(p, l.Timing)
+			if err != nil {
+				return nil, err
+			}
+			if line.Text == "" {
+				continue
+			}
+			line.Part = div.SongPart
+			if line.Translations, err = lookup(translations, p.Key); err != nil {
+				return nil, err
+			}
+			if line.Transliterations, err = lookup(transliterations, p.Key); err != nil {
+				return nil, err
+			}
+			l.Lines = append(l.Lines, line)
+		}
+	}
+	return l, nil
+}
+
+func parseLine(p *span, timing string) (Line, error) {
+	line := Line{Key: p.Key, Agent: p.Agent}
+	if timing == TimingNone {
+		line.Text = collapse(p.plain())
+		return line, nil
+	}
+	var err error
+	if line.Begin, err = parseTime(p.Begin); err != nil {
+		return line, err
+	}
+	if line.End, err = parseTime(p.End); err != nil {
+		return line, err
+	}
+	if timing == TimingWord {
+		if line.Words, err = p.words(); err != nil {
+			return line, err
+		}
+		line.Text = joinWords(line.Words)
+	} else {
+		line.Text = collapse(p.plain())
+	}
+	if bg := p.background(); bg != nil {
+		if line.Background, err = bg.text(""); err != nil {
+			return line, err
+		}
+	}
+	return line, nil
+}
+
+type keyedSet struct {
+	lang  string
+	texts map[string]*span
+}
+
+// keyed indexes translations or transliterations by the line key.
+func keyed(sets []textSet) []keyedSet {
+	var out []keyedSet
+	for _, set := range sets {
+		k := keyedSet{lang: set.Lang, texts: make(map[string]*span)}
+		for i := range set.Texts {
+			k.texts[set.Texts[i].For] = &set.Texts[i]
+		}
+		out = append(out, k)
+	}
+	return out
+}
+
+func lookup(sets []keyedSet, key string) ([]Text, error) {
+	var out []Text
+	for _, set := range sets {
+		s, ok := set.texts[key]
+		if !ok || key == "" {
+			continue
+		}
+		t, err := s.text(set.lang)
+		if err != nil {
+			return nil, err
+		}
+		if t.Text != "" {
+			out = append(out, *t)
+		}
+	}
+	return out, nil
+}
+
+func joinWords(words []Word) string {
+	var b strings.Builder
+	for _, w := range words {
+		b.WriteString(w.Text)
+	}
+	return collapse(b.String())
+}
+
+// collapse trims s and folds runs of white space into single spaces.
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// parseTime reads a TTML time expression: a clock time such as "1:02.345"
+// or "1:00:02.3" (Apple omits the hours and often the minutes), or an
+// offset time such as "62.345s", "1500ms" or "1.5m". The fraction may have
+// any number of digits. An empty value is zero.
+func parseTime(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	num, scale := value, 1.0
+	for _, unit := range []struct {
+		suffix string
+		scale  float64
+	}{{"ms", 0.001}, {"h", 3600}, {"m", 60}, {"s", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			num, scale = strings.TrimSuffix(value, unit.suffix), unit.scale
+			break
+		}
+	}
+	parts := strings.Split(num, ":")
+	if len(parts) > 3 || (len(parts) > 1 && num != value) {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	var total float64
+	for _, part := range parts {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil || f < 0 {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		total = total*60 + f
+	}
+	return time.Duration(math.Round(total*scale*1000)) * time.Millisecond, nil
+}
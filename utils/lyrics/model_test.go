@@ -0,0 +1,274 @@
+package lyrics
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestWriters renders each testdata/*.ttml fixture in every format and
+// compares the result to testdata/<fixture>.<format>.golden. The ttml writer
+// is given the parsed lyrics without their source, so it builds a document.
+func TestWriters(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "*.ttml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures in testdata")
+	}
+	for _, fixture := range fixtures {
+		data, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := Parse(string(data))
+		if err != nil {
+			t.Fatalf("%s: %v", fixture, err)
+		}
+		for _, format := range Formats() {
+			name := fixture[:len(fixture)-len(".ttml")] + "." + format + ".golden"
+			t.Run(filepath.Base(name), func(t *testing.T) {
+				w, _ := Lookup(format)
+				in := *l
+				if format == "ttml" {
+					in.Source = ""
+				}
+				got, err := w.Write(&in)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *update {
+					if err := os.WriteFile(name, []byte(got), 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != string(want) {
+					t.Errorf("got\n%s\nwant\n%s", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestParseDuet(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "duet.ttml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Parse(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Agent{{"v1", "person", "Ann"}, {"v2", "person", "Bob & Co"}, {"v1000", "group", ""}}
+	if !reflect.DeepEqual(l.Agents, want) {
+		t.Errorf("Agents = %+v, want %+v", l.Agents, want)
+	}
+	if got := l.AgentName("v1000"); got != "v1000" {
+		t.Errorf("AgentName(v1000) = %q, want the ID", got)
+	}
+	for i, agent := range []string{"v1", "v2", "v1000"} {
+		if l.Lines[i].Agent != agent {
+			t.Errorf("line %d agent = %q, want %q", i+1, l.Lines[i].Agent, agent)
+		}
+	}
+}
+
+func TestParseUnsynced(t *testing.T) {
+	l, err := Parse(`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:itunes="http://music.apple.com/lyric-ttml-internal" itunes:timing="None"><body><div><p>One</p><p>Two</p></div></body></tt>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Synced() || len(l.Lines) != 2 {
+		t.Fatalf("Parse() = %+v, want two unsynced lines", l)
+	}
+	for _, format := range []string{"srt", "vtt"} {
+		w, _ := Lookup(format)
+		if _, err := w.Write(l); !errors.Is(err, ErrNotSynced) {
+			t.Errorf("%s writer error = %v, want ErrNotSynced", format, err)
+		}
+	}
+	if got, _ := (elrcWriter{}).Write(l); got != "One\nTwo\n" {
+		t.Errorf("elrc of unsynced lyrics = %q", got)
+	}
+	if _, err := Parse("<p>not ttml</p>"); err == nil {
+		t.Error("Parse() accepted a document that is not TTML")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"9.5", 9500 * time.Millisecond},
+		{"1:02.3", 62300 * time.Millisecond},
+		{"1:02.34", 62340 * time.Millisecond},
+		{"1:02.345", 62345 * time.Millisecond},
+		{"1:02.3456", 62346 * time.Millisecond},
+		{"1:00:02.5", time.Hour + 2500*time.Millisecond},
+		{"62.345s", 62345 * time.Millisecond},
+		{"1500ms", 1500 * time.Millisecond},
+		{"1.5m", 90 * time.Second},
+		{"1h", time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseTime(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"abc", "1:2:3:4", "1:02s", "-1.5", "1:-2"} {
+		if _, err := parseTime(in); err == nil {
+			t.Errorf("parseTime(%q) succeeded", in)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+	line := func(begin, end int, text string, words ...Word) Line {
+		return Line{Begin: ms(begin), End: ms(end), Text: text, Words: words}
+	}
+	word := func(begin, end int, text string) Word { return Word{Begin: ms(begin), End: ms(end), Text: text} }
+	tests := []struct {
+		name     string
+		l        Lyrics
+		duration time.Duration
+		want     []string
+	}{
+		{"clean", Lyrics{Timing: TimingLine, Lines: []Line{line(1000, 2000, "One"), line(2000, 3000, "Two")}}, ms(3000), nil},
+		{"no lines", Lyrics{Timing: TimingLine}, ms(3000), []string{"no lyric lines"}},
+		{"empty line", Lyrics{Timing: TimingLine, Lines: []Line{line(1000, 2000, "One"), line(2000, 3000, " ")}}, 0, []string{"line 2: empty line"}},
+		{"empty unsynced line", Lyrics{Timing: TimingNone, Lines: []Line{{Text: ""}, {Text: "Two"}}}, 0, []string{"line 1: empty line"}},
+		{"lines backwards", Lyrics{Timing: TimingLine, Lines: []Line{line(2000, 3000, "One"), line(1000, 0, "Two")}}, 0, []string{
+			"line 2: starts at 00:00:01.000, before the previous line at 00:00:02.000",
+		}},
+		{"line ends before it starts", Lyrics{Timing: TimingLine, Lines: []Line{line(2000, 1500, "One")}}, 0, []string{
+			"line 1: ends at 00:00:01.500, before it starts at 00:00:02.000",
+		}},
+		{"words backwards", Lyrics{Timing: TimingWord, Lines: []Line{line(1000, 3000, "One two", word(2000, 3000, "One "), word(1000, 1500, "two"))}}, 0, []string{
+			`line 1: word "two" starts at 00:00:01.000, before the previous word`,
+		}},
+		{"word ends before it starts", Lyrics{Timing: TimingWord, Lines: []Line{line(1000, 3000, "One", word(2000, 1000, "One"))}}, 0, []string{
+			`line 1: word "One" ends at 00:00:01.000, before it starts at 00:00:02.000`,
+		}},
+		{"ends after the track", Lyrics{Timing: TimingWord, Lines: []Line{line(1000, 2000, "One", word(1000, 2500, "One"))}}, ms(2200), []string{
+			"line 1: ends at 00:00:02.500, after the track ends at 00:00:02.200",
+		}},
+		{"zero duration", Lyrics{Timing: TimingLine, Lines: []Line{line(1000, 9000, "One")}}, 0, nil},
+		{"unsynced out of order", Lyrics{Timing: TimingNone, Lines: []Line{line(2000, 0, "One"), line(1000, 0, "Two")}}, ms(500), nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range tt.l.Validate(tt.duration) {
+			got = append(got, p.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Validate() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	bad := &Lyrics{Timing: TimingLine, Lines: []Line{{Begin: 2 * time.Second}, {Begin: time.Second, Text: "Two"}}}
+	good := &Lyrics{Timing: TimingLine, Lines: []Line{{Begin: time.Second, Text: "One"}}}
+	tests := []struct {
+		name    string
+		l       *Lyrics
+		mode    string
+		wantMsg string
+		wantOK  bool
+	}{
+		{"report", bad, "report", "line 1: empty line (and 1 more)", true},
+		{"skip", bad, "skip", "line 1: empty line (and 1 more), lyrics skipped", false},
+		{"off", bad, "off", "", true},
+		{"no problems", good, "skip", "", true},
+	}
+	for _, tt := range tests {
+		msg, ok := tt.l.Check(0, tt.mode)
+		if msg != tt.wantMsg || ok != tt.wantOK {
+			t.Errorf("%s: Check() = %q, %v; want %q, %v", tt.name, msg, ok, tt.wantMsg, tt.wantOK)
+		}
+	}
+}
+
+func TestShift(t *testing.T) {
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+	words := func(begin, end int) []Word { return []Word{{Begin: ms(begin), End: ms(end), Text: "w"}} }
+	in := func() *Lyrics {
+		return &Lyrics{Timing: TimingWord, Source: "<tt/>", Lines: []Line{{
+			Begin:            ms(200),
+			End:              ms(1000),
+			Words:            words(200, 1000),
+			Background:       &Text{Words: words(300, 900)},
+			Translations:     []Text{{Words: words(200, 600)}},
+			Transliterations: []Text{{Words: words(600, 1000)}},
+		}, {
+			Begin: ms(2000), // no end
+		}}}
+	}
+	tests := []struct {
+		name  string
+		shift time.Duration
+		want  [][2]time.Duration // begin and end of the line, its word, background, translation and transliteration
+	}{
+		{"later", ms(500), [][2]time.Duration{{ms(700), ms(1500)}, {ms(700), ms(1500)}, {ms(800), ms(1400)}, {ms(700), ms(1100)}, {ms(1100), ms(1500)}, {ms(2500), 0}}},
+		{"earlier, clamped at zero", ms(-400), [][2]time.Duration{{0, ms(600)}, {0, ms(600)}, {0, ms(500)}, {0, ms(200)}, {ms(200), ms(600)}, {ms(1600), 0}}},
+		{"none", 0, [][2]time.Duration{{ms(200), ms(1000)}, {ms(200), ms(1000)}, {ms(300), ms(900)}, {ms(200), ms(600)}, {ms(600), ms(1000)}, {ms(2000), 0}}},
+	}
+	for _, tt := range tests {
+		l := in()
+		l.Shift(tt.shift)
+		first := l.Lines[0]
+		got := [][2]time.Duration{
+			{first.Begin, first.End},
+			{first.Words[0].Begin, first.Words[0].End},
+			{first.Background.Words[0].Begin, first.Background.Words[0].End},
+			{first.Translations[0].Words[0].Begin, first.Translations[0].Words[0].End},
+			{first.Transliterations[0].Words[0].Begin, first.Transliterations[0].Words[0].End},
+			{l.Lines[1].Begin, l.Lines[1].End},
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Shift() times = %v, want %v", tt.name, got, tt.want)
+		}
+		if wantSource := tt.shift == 0; (l.Source != "") != wantSource {
+			t.Errorf("%s: Source = %q after Shift(%v)", tt.name, l.Source, tt.shift)
+		}
+	}
+}
+
+func TestOffset(t *testing.T) {
+	offsets := map[string]int{"1440818664": -350, "USUM71703861": 200, "": 999}
+	tests := []struct {
+		name     string
+		id, isrc string
+		want     time.Duration
+	}{
+		{"song ID", "1440818664", "USXX00000000", -350 * time.Millisecond},
+		{"ISRC", "1", "USUM71703861", 200 * time.Millisecond},
+		{"song ID before ISRC", "1440818664", "USUM71703861", -350 * time.Millisecond},
+		{"fallback", "1", "USXX00000000", 100 * time.Millisecond},
+		{"empty keys are ignored", "", "", 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := Offset(offsets, 100, tt.id, tt.isrc); got != tt.want {
+			t.Errorf("%s: Offset() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := Offset(nil, -50, "1"); got != -50*time.Millisecond {
+		t.Errorf("Offset() without track offsets = %v, want -50ms", got)
+	}
+}
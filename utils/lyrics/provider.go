@@ -0,0 +1,208 @@
+package lyrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by providers that have no lyrics for a song.
+var ErrNotFound = errors.New("no lyrics found")
+
+// defaultClient is used by the providers that are given no Client, so that
+// a server that never answers cannot hold up the rest of a Chain.
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// Query identifies the song whose lyrics are wanted. Providers use the
+// fields they understand; Path is the song's audio file, if there is one.
+type Query struct {
+	Storefront string
+	SongID     string
+	ISRC       string
+	Title      string
+	Artist     string
+	Album      string
+	DurationMs int
+	Path       string
+}
+
+// A Provider is a source of lyrics.
+type Provider interface {
+	Name() string
+	Fetch(q Query) (*Lyrics, error)
+}
+
+// Chain tries its providers in order and returns the first lyrics found.
+type Chain []Provider
+
+func (c Chain) Name() string {
+	var names []string
+	for _, p := range c {
+		names = append(names, p.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// Fetch returns ErrNotFound when no provider has lyrics, or else the errors
+// of the providers that failed.
+func (c Chain) Fetch(q Query) (*Lyrics, error) {
+	var errs []error
+	for _, p := range c {
+		l, err := p.Fetch(q)
+		if err == nil && len(l.Lines) > 0 {
+			return l, nil
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil, ErrNotFound
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Apple fetches lyrics from the Apple Music catalog, which needs a
+// media-user-token. BaseURL and Client default to the Apple Music API and
+// a client with a 30 second timeout.
+type Apple struct {
+	Token          string
+	MediaUserToken string
+	Type           string // lyrics or syllable-lyrics
+	Language       string
+	BaseURL        string
+	Client         *http.Client
+}
+
+func (Apple) Name() string { return "apple" }
+
+func (p Apple) Fetch(q Query) (*Lyrics, error) {
+	if q.SongID == "" {
+		return nil, ErrNotFound
+	}
+	if len(p.MediaUserToken) < 50 {
+		return nil, errors.New("MediaUserToken not set")
+	}
+	client, base := p.Client, p.BaseURL
+	if client == nil {
+		client = defaultClient
+	}
+	if base == "" {
+		base = appleBaseURL
+	}
+	ttml, err := getSongLyrics(client, base, q.SongID, q.Storefront, p.Token, p.MediaUserToken, p.Type, p.Language)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(ttml)
+}
+
+// Local reads .ttml or .lrc files. Without a Dir it looks for the file
+// named like the audio file next to it; with one it looks in Dir for a file
+// named like the audio file, or after the song ID, the ISRC or
+// "Artist - Title".
+type Local struct {
+	Dir string
+}
+
+func (Local) Name() string { return "local" }
+
+func (p Local) Fetch(q Query) (*Lyrics, error) {
+	var candidates []string
+	if p.Dir == "" {
+		if q.Path == "" {
+			return nil, ErrNotFound
+		}
+		candidates = append(candidates, strings.TrimSuffix(q.Path, filepath.Ext(q.Path)))
+	} else {
+		names := []string{strings.TrimSuffix(filepath.Base(q.Path), filepath.Ext(q.Path)), q.SongID, q.ISRC}
+		if q.Artist != "" && q.Title != "" {
+			names = append(names, q.Artist+" - "+q.Title)
+		}
+		for _, name := range names {
+			if name == "" || name == "." || strings.ContainsAny(name, `/\`) {
+				continue
+			}
+			candidates = append(candidates, filepath.Join(p.Dir, name))
+		}
+	}
+	for _, c := range candidates {
+		for _, ext := range []string{".ttml", ".lrc"} {
+			data, err := os.ReadFile(c + ext)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			l, err := decode(string(data))
+			if err != nil {
+				return nil, err
+			}
+			l.File = c + ext
+			return l, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// HTTP fetches lyrics from a URL built from Template, whose placeholders
+// {id}, {isrc}, {title}, {artist}, {album}, {storefront} and {duration} (in
+// milliseconds) are replaced by the query-escaped values of the song. The
+// response may be TTML or LRC; a 404 means there are none. Client defaults
+// to a client with a 30 second timeout.
+type HTTP struct {
+	Template string
+	Client   *http.Client
+}
+
+func (HTTP) Name() string { return "http" }
+
+func (p HTTP) Fetch(q Query) (*Lyrics, error) {
+	u := strings.NewReplacer(
+		"{id}", url.QueryEscape(q.SongID),
+		"{isrc}", url.QueryEscape(q.ISRC),
+		"{title}", url.QueryEscape(q.Title),
+		"{artist}", url.QueryEscape(q.Artist),
+		"{album}", url.QueryEscape(q.Album),
+		"{storefront}", url.QueryEscape(q.Storefront),
+		"{duration}", strconv.Itoa(q.DurationMs),
+	).Replace(p.Template)
+	client := p.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decode(string(data))
+}
+
+// decode parses a TTML or LRC document.
+func decode(data string) (*Lyrics, error) {
+	data = strings.TrimPrefix(strings.TrimSpace(data), "\ufeff")
+	if strings.HasPrefix(data, "<") {
+		return Parse(data)
+	}
+	return ParseLRC(data)
+}
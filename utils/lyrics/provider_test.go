@@ -0,0 +1,209 @@
+package lyrics
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTTML = `<tt xmlns="http://www.w3.org/ns/ttml" xmlns:itunes="http://music.apple.com/lyric-ttml-internal" itunes:timing="Line"><body><div><p begin="1.0" end="2.0">Hello</p></div></body></tt>`
+
+var mediaUserToken = strings.Repeat("m", 50)
+
+// songs serves the catalog lyrics of song 1, a 404 for song 2 and a 500 for
+// anything else.
+func songs(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("media-user-token"); err != nil || c.Value != mediaUserToken {
+			t.Errorf("request without the media-user-token cookie: %v", err)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		switch r.URL.Path {
+		case "/v1/catalog/us/songs/1/syllable-lyrics":
+			json.NewEncoder(w).Encode(map[string]any{"data": []any{
+				map[string]any{"attributes": map[string]string{"ttmlLocalizations": testTTML}},
+			}})
+		case "/v1/catalog/us/songs/2/syllable-lyrics":
+			http.NotFound(w, r)
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestApple(t *testing.T) {
+	srv := songs(t)
+	p := Apple{Token: "token", MediaUserToken: mediaUserToken, Type: "syllable-lyrics", Language: "en", BaseURL: srv.URL, Client: srv.Client()}
+	l, err := p.Fetch(Query{Storefront: "us", SongID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Lines) != 1 || l.Lines[0].Text != "Hello" {
+		t.Errorf("Fetch() = %+v", l.Lines)
+	}
+	if _, err := p.Fetch(Query{Storefront: "us", SongID: "2"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Fetch() of a 404 = %v, want ErrNotFound", err)
+	}
+	if _, err := p.Fetch(Query{Storefront: "us", SongID: "3"}); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Fetch() of a 500 = %v, want a server error", err)
+	}
+	if _, err := p.Fetch(Query{Storefront: "us"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Fetch() without a song ID = %v, want ErrNotFound", err)
+	}
+	p.MediaUserToken = ""
+	if _, err := p.Fetch(Query{Storefront: "us", SongID: "1"}); err == nil {
+		t.Error("Fetch() without a media-user-token succeeded")
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("artist") == "AC/DC & Co" && q.Get("title") == "Song":
+			w.Write([]byte("\ufeff[00:01.00]Hello\n[00:02.00]World\n"))
+		case q.Get("isrc") == "missing":
+			http.NotFound(w, r)
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	p := HTTP{Template: srv.URL + "/?artist={artist}&title={title}&isrc={isrc}&ms={duration}", Client: srv.Client()}
+	l, err := p.Fetch(Query{Artist: "AC/DC & Co", Title: "Song"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Lines) != 2 || l.Lines[1].Text != "World" {
+		t.Errorf("Fetch() = %+v", l.Lines)
+	}
+	if _, err := p.Fetch(Query{ISRC: "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Fetch() of a 404 = %v, want ErrNotFound", err)
+	}
+	if _, err := p.Fetch(Query{ISRC: "other"}); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("Fetch() of a 500 = %v, want the status", err)
+	}
+}
+
+func TestDefaultClientTimeout(t *testing.T) {
+	if defaultClient.Timeout <= 0 {
+		t.Error("default client has no timeout")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("01 Song.lrc", "[00:01.00]Next to the file\n")
+	write("USAAA0000001.ttml", testTTML)
+	write("Artist - Title.lrc", "[00:01.00]By name\n")
+
+	tests := []struct {
+		name string
+		p    Local
+		q    Query
+		want string
+	}{
+		{"next to the file", Local{}, Query{Path: filepath.Join(dir, "01 Song.m4a")}, "Next to the file"},
+		{"by ISRC", Local{Dir: dir}, Query{Path: "/music/02 Other.m4a", ISRC: "USAAA0000001"}, "Hello"},
+		{"by artist and title", Local{Dir: dir}, Query{Artist: "Artist", Title: "Title"}, "By name"},
+		{"file name first", Local{Dir: dir}, Query{Path: "/music/01 Song.m4a", ISRC: "USAAA0000001"}, "Next to the file"},
+		{"no separators", Local{Dir: dir}, Query{Artist: "AC/DC", Title: "Title"}, ""},
+		{"nothing", Local{Dir: dir}, Query{SongID: "1"}, ""},
+		{"no path", Local{}, Query{ISRC: "USAAA0000001"}, ""},
+	}
+	for _, tt := range tests {
+		l, err := tt.p.Fetch(tt.q)
+		if tt.want == "" {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: Fetch() = %v, want ErrNotFound", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(l.Lines) == 0 || l.Lines[0].Text != tt.want {
+			t.Errorf("%s: Fetch() = %+v, want %q", tt.name, l.Lines, tt.want)
+		}
+		if filepath.Dir(l.File) != dir {
+			t.Errorf("%s: File = %q, want a file in %s", tt.name, l.File, dir)
+		}
+	}
+	l, err := Local{}.Fetch(Query{Path: filepath.Join(dir, "01 Song.m4a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "01 Song.lrc"); l.File != want {
+		t.Errorf("Fetch() File = %q, want %q", l.File, want)
+	}
+}
+
+// stub is a provider that returns fixed results and counts its calls.
+type stub struct {
+	name  string
+	l     *Lyrics
+	err   error
+	calls *int
+}
+
+func (s stub) Name() string { return s.name }
+
+func (s stub) Fetch(Query) (*Lyrics, error) {
+	*s.calls++
+	return s.l, s.err
+}
+
+func TestChain(t *testing.T) {
+	found := &Lyrics{Lines: []Line{{Text: "Hello"}}}
+	empty := &Lyrics{}
+	boom, down := errors.New("boom"), errors.New("down")
+	var calls [3]int
+	chain := func(a, b, c stub) Chain {
+		a.calls, b.calls, c.calls = &calls[0], &calls[1], &calls[2]
+		calls = [3]int{}
+		return Chain{a, b, c}
+	}
+
+	c := chain(stub{name: "a", err: ErrNotFound}, stub{name: "b", l: empty}, stub{name: "c", l: found})
+	if c.Name() != "a,b,c" {
+		t.Errorf("Name() = %q", c.Name())
+	}
+	if l, err := c.Fetch(Query{}); err != nil || l != found {
+		t.Errorf("Fetch() = %v, %v; want the lyrics of the last provider", l, err)
+	}
+
+	c = chain(stub{name: "a", err: boom}, stub{name: "b", l: found}, stub{name: "c", l: found})
+	if l, err := c.Fetch(Query{}); err != nil || l != found || calls != [3]int{1, 1, 0} {
+		t.Errorf("Fetch() = %v, %v after calls %v; want to stop at the first lyrics", l, err, calls)
+	}
+
+	c = chain(stub{name: "a", err: ErrNotFound}, stub{name: "b", l: empty}, stub{name: "c", err: ErrNotFound})
+	if _, err := c.Fetch(Query{}); err != ErrNotFound {
+		t.Errorf("Fetch() with no lyrics = %v, want ErrNotFound", err)
+	}
+
+	c = chain(stub{name: "a", err: boom}, stub{name: "b", err: ErrNotFound}, stub{name: "c", err: down})
+	_, err := c.Fetch(Query{})
+	if !errors.Is(err, boom) || !errors.Is(err, down) || errors.Is(err, ErrNotFound) {
+		t.Errorf("Fetch() = %v, want the errors of a and c", err)
+	}
+	if err != nil && err.Error() != "a: boom\nc: down" {
+		t.Errorf("Fetch() error = %q", err)
+	}
+}
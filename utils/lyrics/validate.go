@@ -0,0 +1,139 @@
+package lyrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Problem is a defect found by Validate. Line is 1-based, or 0 when the
+// problem concerns the whole document.
+type Problem struct {
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// Validate checks that l has lines, that none is empty and, for synced
+// lyrics, that lines and words run forward in time and that nothing ends
+// after duration. A zero duration skips the last check.
+func (l *Lyrics) Validate(duration time.Duration) []Problem {
+	if len(l.Lines) == 0 {
+		return []Problem{{Message: "no lyric lines"}}
+	}
+	var problems []Problem
+	add := func(i int, format string, args ...interface{}) {
+		problems = append(problems, Problem{Line: i + 1, Message: fmt.Sprintf(format, args...)})
+	}
+	for i, line := range l.Lines {
+		if strings.TrimSpace(line.Text) == "" {
+			add(i, "empty line")
+		}
+		if !l.Synced() {
+			continue
+		}
+		if i > 0 && line.Begin < l.Lines[i-1].Begin {
+			add(i, "starts at %s, before the previous line at %s", clock(line.Begin, "."), clock(l.Lines[i-1].Begin, "."))
+		}
+		if line.End != 0 && line.End < line.Begin {
+			add(i, "ends at %s, before it starts at %s", clock(line.End, "."), clock(line.Begin, "."))
+		}
+		for j, w := range line.Words {
+			if j > 0 && w.Begin < line.Words[j-1].Begin {
+				add(i, "word %q starts at %s, before the previous word", strings.TrimSpace(w.Text), clock(w.Begin, "."))
+			}
+			if w.End < w.Begin {
+				add(i, "word %q ends at %s, before it starts at %s", strings.TrimSpace(w.Text), clock(w.End, "."), clock(w.Begin, "."))
+			}
+		}
+		if end := lineEnd(line); duration > 0 && end > duration {
+			add(i, "ends at %s, after the track ends at %s", clock(end, "."), clock(duration, "."))
+		}
+	}
+	return problems
+}
+
+// Check validates l against duration as the lrc-validation mode asks:
+// report, skip or off. msg sums up the problems found, or is empty when
+// there are none, and ok is false when the lyrics are to be dropped.
+func (l *Lyrics) Check(duration time.Duration, mode string) (msg string, ok bool) {
+	if mode == "off" {
+		return "", true
+	}
+	problems := l.Validate(duration)
+	if len(problems) == 0 {
+		return "", true
+	}
+	msg = problems[0].String()
+	if len(problems) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(problems)-1)
+	}
+	if mode == "skip" {
+		return msg + ", lyrics skipped", false
+	}
+	return msg, true
+}
+
+// Offset returns the shift of a song's lyrics: the entry in offsets under
+// the first of keys that has one, e.g. the song ID and then the ISRC, or
+// else fallback. Both are in milliseconds.
+func Offset(offsets map[string]int, fallback int, keys ...string) time.Duration {
+	ms := fallback
+	for _, key := range keys {
+		if v, ok := offsets[key]; ok && key != "" {
+			ms = v
+			break
+		}
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// lineEnd returns the latest time of line.
+func lineEnd(line Line) time.Duration {
+	end := max(line.Begin, line.End)
+	for _, w := range line.Words {
+		end = max(end, w.End)
+	}
+	return end
+}
+
+// Shift moves every timestamp of l by d, stopping at zero. The TTML source
+// no longer matches, so the ttml format is rebuilt from the lines.
+func (l *Lyrics) Shift(d time.Duration) {
+	if d == 0 {
+		return
+	}
+	l.Source = ""
+	shift := func(t *time.Duration) {
+		*t = max(*t+d, 0)
+	}
+	shiftWords := func(words []Word) {
+		for i := range words {
+			shift(&words[i].Begin)
+			shift(&words[i].End)
+		}
+	}
+	for i := range l.Lines {
+		line := &l.Lines[i]
+		shift(&line.Begin)
+		if line.End != 0 {
+			shift(&line.End)
+		}
+		shiftWords(line.Words)
+		if line.Background != nil {
+			shiftWords(line.Background.Words)
+		}
+		for j := range line.Translations {
+			shiftWords(line.Translations[j].Words)
+		}
+		for j := range line.Transliterations {
+			shiftWords(line.Transliterations[j].Words)
+		}
+	}
+}
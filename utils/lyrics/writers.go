@@ -0,0 +1,362 @@
+package lyrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Writer renders lyrics in one sidecar format.
+type Writer interface {
+	Ext() string // file extension, with the dot
+	Write(l *Lyrics) (string, error)
+}
+
+var writers = map[string]Writer{
+	"ttml": ttmlWriter{},
+	"lrc":  lrcWriter{},
+	"elrc": elrcWriter{},
+	"srt":  srtWriter{},
+	"vtt":  vttWriter{},
+	"txt":  txtWriter{},
+	"json": jsonWriter{},
+}
+
+// ErrNotSynced is returned by the writers of formats that need timestamps.
+var ErrNotSynced = errors.New("lyrics are not synchronised")
+
+// Register adds or replaces the writer of a format.
+func Register(format string, w Writer) {
+	writers[format] = w
+}
+
+// Lookup returns the writer of a format.
+func Lookup(format string) (Writer, bool) {
+	w, ok := writers[format]
+	return w, ok
+}
+
+// Formats lists the known format names.
+func Formats() []string {
+	var names []string
+	for name := range writers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render converts a TTML document to format.
+func Render(ttml, format string) (string, error) {
+	w, ok := writers[format]
+	if !ok {
+		return "", fmt.Errorf("unknown lyrics format %q", format)
+	}
+	l, err := Parse(ttml)
+	if err != nil {
+		return "", err
+	}
+	return w.Write(l)
+}
+
+type ttmlWriter struct{}
+
+func (ttmlWriter) Ext() string { return ".ttml" }
+
+// Write returns the document the lyrics were parsed from, or builds one for
+// lyrics that came from another format.
+func (ttmlWriter) Write(l *Lyrics) (string, error) {
+	if l.Source != "" {
+		return l.Source, nil
+	}
+	var b strings.Builder
+	b.WriteString(`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:itunes="http://music.apple.com/lyric-ttml-internal"`)
+	fmt.Fprintf(&b, ` itunes:timing="%s"`, l.Timing)
+	if l.Language != "" {
+		fmt.Fprintf(&b, ` xml:lang="%s"`, xmlEscape(l.Language))
+	}
+	b.WriteString("><body><div>\n")
+	for _, line := range l.Lines {
+		if !l.Synced() {
+			fmt.Fprintf(&b, "<p>%s</p>\n", xmlEscape(line.Text))
+			continue
+		}
+		fmt.Fprintf(&b, `<p begin="%s"`, clock(line.Begin, "."))
+		if line.End > line.Begin {
+			fmt.Fprintf(&b, ` end="%s"`, clock(line.End, "."))
+		}
+		b.WriteByte('>')
+		if len(line.Words) == 0 {
+			b.WriteString(xmlEscape(line.Text))
+		}
+		for _, w := range line.Words {
+			fmt.Fprintf(&b, `<span begin="%s" end="%s">%s</span>`,
+				clock(w.Begin, "."), clock(w.End, "."), xmlEscape(strings.TrimRight(w.Text, " ")))
+			if strings.HasSuffix(w.Text, " ") {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString("</p>\n")
+	}
+	b.WriteString("</div></body></tt>\n")
+	return b.String(), nil
+}
+
+var xmlEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace
+
+// lrcWriter writes LRC as the downloader always has: enhanced LRC for
+// word-timed lyrics, each line preceded by its translation, and lines in a
+// CJK script replaced by their transliteration.
+type lrcWriter struct{}
+
+func (lrcWriter) Ext() string { return ".lrc" }
+
+func (lrcWriter) Write(l *Lyrics) (string, error) {
+	var lines []string
+	for _, line := range l.Lines {
+		if !l.Synced() {
+			lines = append(lines, line.Text)
+			continue
+		}
+		if len(line.Translations) > 0 {
+			lines = append(lines, fmt.Sprintf("[%s]%s", lrcTime(line.Begin), line.Translations[0].Text))
+		}
+		text := Text{Text: line.Text, Words: line.Words}
+		if len(line.Transliterations) > 0 && containsCJK(line.Text) {
+			text = line.Transliterations[0]
+		}
+		lines = append(lines, lrcLine(line.Begin, text))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// elrcWriter writes enhanced (A2) LRC with a timestamp before each word.
+// Line-timed lyrics come out as plain LRC.
+type elrcWriter struct{}
+
+func (elrcWriter) Ext() string { return ".lrc" }
+
+func (elrcWriter) Write(l *Lyrics) (string, error) {
+	if !l.Synced() {
+		return txtWriter{}.Write(l)
+	}
+	var b strings.Builder
+	for _, line := range l.Lines {
+		b.WriteString(lrcLine(line.Begin, Text{Text: line.Text, Words: line.Words}))
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// lrcLine formats [mm:ss.xx]text, with a timestamp before each word and
+// after the last one when t has word timing.
+func lrcLine(begin time.Duration, t Text) string {
+	if len(t.Words) == 0 {
+		return fmt.Sprintf("[%s]%s", lrcTime(begin), t.Text)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]", lrcTime(t.Words[0].Begin))
+	for _, w := range t.Words {
+		fmt.Fprintf(&b, "<%s>%s", lrcTime(w.Begin), w.Text)
+	}
+	fmt.Fprintf(&b, "<%s>", lrcTime(t.Words[len(t.Words)-1].End))
+	return b.String()
+}
+
+type srtWriter struct{}
+
+func (srtWriter) Ext() string { return ".srt" }
+
+func (srtWriter) Write(l *Lyrics) (string, error) {
+	if !l.Synced() {
+		return "", ErrNotSynced
+	}
+	var b strings.Builder
+	for i, line := range l.Lines {
+		begin, end := cueTimes(l, i)
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n", i+1,
+			clock(begin, ","), clock(end, ","), line.Text)
+		if line.Background != nil {
+			b.WriteString(line.Background.Text + "\n")
+		}
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// vttWriter writes WebVTT. Word timing becomes inline cue timestamps and
+// duet singers become voice spans.
+type vttWriter struct{}
+
+func (vttWriter) Ext() string { return ".vtt" }
+
+func (vttWriter) Write(l *Lyrics) (string, error) {
+	if !l.Synced() {
+		return "", ErrNotSynced
+	}
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for i, line := range l.Lines {
+		begin, end := cueTimes(l, i)
+		fmt.Fprintf(&b, "%s --> %s\n", clock(begin, "."), clock(end, "."))
+		if line.Agent != "" && len(l.Agents) > 1 {
+			fmt.Fprintf(&b, "<v %s>", vttEscape(l.AgentName(line.Agent)))
+		}
+		b.WriteString(vttText(Text{Text: line.Text, Words: line.Words}))
+		if line.Background != nil {
+			b.WriteString("\n" + vttText(*line.Background))
+		}
+		b.WriteString("\n\n")
+	}
+	return b.String(), nil
+}
+
+// vttText writes the words of t separated by cue timestamps.
+func vttText(t Text) string {
+	if len(t.Words) == 0 {
+		return vttEscape(t.Text)
+	}
+	var b strings.Builder
+	for j, w := range t.Words {
+		if j > 0 {
+			fmt.Fprintf(&b, "<%s>", clock(w.Begin, "."))
+		}
+		b.WriteString(vttEscape(w.Text))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+var vttEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+
+// txtWriter writes one line of text per lyric line, background vocals
+// included.
+type txtWriter struct{}
+
+func (txtWriter) Ext() string { return ".txt" }
+
+func (txtWriter) Write(l *Lyrics) (string, error) {
+	var b strings.Builder
+	for _, line := range l.Lines {
+		b.WriteString(line.Text)
+		if line.Background != nil {
+			b.WriteString(" " + line.Background.Text)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// jsonWriter writes the lyrics model with times in milliseconds.
+type jsonWriter struct{}
+
+func (jsonWriter) Ext() string { return ".json" }
+
+type jsonWord struct {
+	Begin int64  `json:"begin"`
+	End   int64  `json:"end"`
+	Text  string `json:"text"`
+}
+
+type jsonText struct {
+	Language string     `json:"language,omitempty"`
+	Text     string     `json:"text"`
+	Words    []jsonWord `json:"words,omitempty"`
+}
+
+type jsonLine struct {
+	Begin            int64      `json:"begin"`
+	End              int64      `json:"end"`
+	Key              string     `json:"key,omitempty"`
+	Agent            string     `json:"agent,omitempty"`
+	Part             string     `json:"part,omitempty"`
+	Text             string     `json:"text"`
+	Words            []jsonWord `json:"words,omitempty"`
+	Background       *jsonText  `json:"background,omitempty"`
+	Translations     []jsonText `json:"translations,omitempty"`
+	Transliterations []jsonText `json:"transliterations,omitempty"`
+}
+
+type jsonLyrics struct {
+	Timing      string     `json:"timing"`
+	Language    string     `json:"language,omitempty"`
+	Songwriters []string   `json:"songwriters,omitempty"`
+	Agents      []Agent    `json:"agents,omitempty"`
+	Lines       []jsonLine `json:"lines"`
+}
+
+func toJSONWords(words []Word) []jsonWord {
+	var out []jsonWord
+	for _, w := range words {
+		out = append(out, jsonWord{w.Begin.Milliseconds(), w.End.Milliseconds(), w.Text})
+	}
+	return out
+}
+
+func toJSONTexts(texts []Text) []jsonText {
+	var out []jsonText
+	for _, t := range texts {
+		out = append(out, jsonText{t.Language, t.Text, toJSONWords(t.Words)})
+	}
+	return out
+}
+
+func (jsonWriter) Write(l *Lyrics) (string, error) {
+	doc := jsonLyrics{
+		Timing:      l.Timing,
+		Language:    l.Language,
+		Songwriters: l.Songwriters,
+		Agents:      l.Agents,
+		Lines:       []jsonLine{},
+	}
+	for _, line := range l.Lines {
+		jl := jsonLine{
+			Begin:            line.Begin.Milliseconds(),
+			End:              line.End.Milliseconds(),
+			Key:              line.Key,
+			Agent:            line.Agent,
+			Part:             line.Part,
+			Text:             line.Text,
+			Words:            toJSONWords(line.Words),
+			Translations:     toJSONTexts(line.Translations),
+			Transliterations: toJSONTexts(line.Transliterations),
+		}
+		if line.Background != nil {
+			jl.Background = &toJSONTexts([]Text{*line.Background})[0]
+		}
+		doc.Lines = append(doc.Lines, jl)
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// cueTimes returns the display interval of line i. Lines without an end
+// last until the next line, or five seconds for the last one.
+func cueTimes(l *Lyrics, i int) (time.Duration, time.Duration) {
+	line := l.Lines[i]
+	end := line.End
+	if end <= line.Begin {
+		end = line.Begin + 5*time.Second
+		if i+1 < len(l.Lines) && l.Lines[i+1].Begin > line.Begin {
+			end = l.Lines[i+1].Begin
+		}
+	}
+	return line.Begin, end
+}
+
+// lrcTime formats mm:ss.xx.
+func lrcTime(d time.Duration) string {
+	cs := d.Milliseconds() / 10
+	return fmt.Sprintf("%02d:%02d.%02d", cs/6000, cs/100%60, cs%100)
+}
+
+// clock formats hh:mm:ss followed by sep and milliseconds.
+func clock(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
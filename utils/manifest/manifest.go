@@ -0,0 +1,243 @@
+// Package manifest keeps a SHA-256 checksum file in each download folder so
+// that archived copies can later be checked for corruption.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the manifest inside a folder.
+const FileName = "checksums.json"
+
+// Kinds of problems found by Verify.
+const (
+	Missing   = "missing"   // listed but not on disk
+	Corrupt   = "corrupt"   // contents changed but size and time did not
+	Modified  = "modified"  // contents changed along with size or time
+	Untracked = "untracked" // audio file not listed
+)
+
+// Entry is one file. Path is relative to the manifest's folder and uses
+// forward slashes.
+type Entry struct {
+	Path    string    `json:"path"`
+	SHA256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	AdamID  string    `json:"adamId,omitempty"`
+	Codec   string    `json:"codec,omitempty"`
+}
+
+// Manifest lists the files of one folder. ID is the album or playlist the
+// folder was downloaded from.
+type Manifest struct {
+	Version int       `json:"version"`
+	ID      string    `json:"id,omitempty"`
+	Updated time.Time `json:"updated"`
+	Files   []Entry   `json:"files"`
+}
+
+// Problem is a file that failed verification.
+type Problem struct {
+	Path   string // absolute
+	Kind   string
+	Detail string
+}
+
+// Load reads the manifest of dir; a missing file gives an empty manifest.
+func Load(dir string) (*Manifest, error) {
+	m := &Manifest{Version: 1}
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, FileName), err)
+	}
+	return m, nil
+}
+
+// Save writes the manifest to dir, replacing the previous one.
+func (m *Manifest) Save(dir string) error {
+	m.Updated = time.Now()
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, FileName)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Put adds e or replaces the entry with the same path.
+func (m *Manifest) Put(e Entry) {
+	for i := range m.Files {
+		if m.Files[i].Path == e.Path {
+			m.Files[i] = e
+			return
+		}
+	}
+	m.Files = append(m.Files, e)
+}
+
+// Has reports whether the file at path is listed in the manifest of dir.
+func (m *Manifest) Has(dir, path string) bool {
+	return m.index(dir, path) >= 0
+}
+
+// Remove drops the entry of the file at path from the manifest of dir.
+func (m *Manifest) Remove(dir, path string) {
+	if i := m.index(dir, path); i >= 0 {
+		m.Files = append(m.Files[:i], m.Files[i+1:]...)
+	}
+}
+
+func (m *Manifest) index(dir, path string) int {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return -1
+	}
+	rel = filepath.ToSlash(rel)
+	for i := range m.Files {
+		if m.Files[i].Path == rel {
+			return i
+		}
+	}
+	return -1
+}
+
+// Hash builds the entry of the file at path for the manifest in dir.
+func Hash(dir, path string) (Entry, error) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return Entry{}, err
+	}
+	sum, info, err := hashFile(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{
+		Path:    filepath.ToSlash(rel),
+		SHA256:  sum,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+func hashFile(path string) (string, fs.FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), info, nil
+}
+
+// Find returns the folder whose manifest covers the file at path: the
+// file's own folder or, for disc subfolders, its parent. It returns "" when
+// neither has a manifest.
+func Find(path string) string {
+	dir := filepath.Dir(path)
+	for _, d := range []string{dir, filepath.Dir(dir)} {
+		if _, err := os.Stat(filepath.Join(d, FileName)); err == nil {
+			return d
+		}
+	}
+	return ""
+}
+
+// Verify re-hashes the files listed in the manifest of dir and returns the
+// problems found and the number of files checked. exts lists the audio
+// extensions (".m4a") whose unlisted files are reported as untracked.
+func Verify(dir string, exts ...string) ([]Problem, int, error) {
+	m, err := Load(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	var problems []Problem
+	listed := make(map[string]bool)
+	for _, e := range m.Files {
+		path := filepath.Join(dir, filepath.FromSlash(e.Path))
+		listed[filepath.Clean(path)] = true
+		sum, info, err := hashFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			problems = append(problems, Problem{path, Missing, "file not found"})
+		case err != nil:
+			problems = append(problems, Problem{path, Corrupt, err.Error()})
+		case sum == e.SHA256:
+		case info.Size() != e.Size || !info.ModTime().Equal(e.ModTime):
+			problems = append(problems, Problem{path, Modified, fmt.Sprintf("changed on %s", info.ModTime().Format("2006-01-02 15:04"))})
+		default:
+			problems = append(problems, Problem{path, Corrupt, "checksum mismatch"})
+		}
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// a subfolder with its own manifest is verified on its own
+			if path != dir && fileExists(filepath.Join(path, FileName)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if listed[filepath.Clean(path)] {
+			return nil
+		}
+		for _, ext := range exts {
+			if strings.EqualFold(filepath.Ext(path), ext) {
+				problems = append(problems, Problem{path, Untracked, "not in the manifest"})
+				break
+			}
+		}
+		return nil
+	})
+	return problems, len(m.Files), err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Folders returns the folders below root that have a manifest.
+func Folders(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == FileName {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	return dirs, err
+}
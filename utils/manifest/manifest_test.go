@@ -0,0 +1,154 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func write(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// album writes an album folder with a disc subfolder and a manifest listing
+// both files, and returns the folder.
+func album(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "01 One.m4a"), "one")
+	write(t, filepath.Join(dir, "Disc 2", "01 Two.m4a"), "two")
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"01 One.m4a", filepath.Join("Disc 2", "01 Two.m4a")} {
+		e, err := Hash(dir, filepath.Join(dir, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Put(e)
+	}
+	if err := m.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestHashPutRemove(t *testing.T) {
+	dir := album(t)
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 2 || m.Files[1].Path != "Disc 2/01 Two.m4a" {
+		t.Fatalf("Files = %+v, want sorted slash paths", m.Files)
+	}
+	// sha256("one")
+	if got := m.Files[0].SHA256; got != "7692c3ad3540bb803c020b3aee66cd8887123234ea0c6e7143c0add73ff431ed" {
+		t.Errorf("SHA256 = %s", got)
+	}
+	two := filepath.Join(dir, "Disc 2", "01 Two.m4a")
+	if !m.Has(dir, two) {
+		t.Error("Has() = false for a listed file")
+	}
+	e := m.Files[1]
+	e.AdamID = "2"
+	m.Put(e)
+	if len(m.Files) != 2 || m.Files[1].AdamID != "2" {
+		t.Errorf("Put() of a listed path did not replace it: %+v", m.Files)
+	}
+	m.Remove(dir, two)
+	if m.Has(dir, two) || len(m.Files) != 1 {
+		t.Errorf("Remove() left %+v", m.Files)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := album(t)
+	one := filepath.Join(dir, "01 One.m4a")
+	two := filepath.Join(dir, "Disc 2", "01 Two.m4a")
+	if problems, checked, err := Verify(dir, ".m4a"); err != nil || len(problems) != 0 || checked != 2 {
+		t.Fatalf("Verify() of an intact folder = %v, %d, %v", problems, checked, err)
+	}
+
+	// same size and time but other contents: bit rot
+	info, err := os.Stat(one)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, one, "ONE")
+	if err := os.Chtimes(one, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(two); err != nil {
+		t.Fatal(err)
+	}
+	write(t, filepath.Join(dir, "02 New.M4A"), "new")
+	write(t, filepath.Join(dir, "cover.jpg"), "jpg")
+	write(t, filepath.Join(dir, "Bonus", FileName), `{"version":1}`)
+	write(t, filepath.Join(dir, "Bonus", "01 Bonus.m4a"), "bonus")
+
+	problems, _, err := Verify(dir, ".m4a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, p := range problems {
+		got[p.Path] = p.Kind
+	}
+	want := map[string]string{
+		one:                              Corrupt,
+		two:                              Missing,
+		filepath.Join(dir, "02 New.M4A"): Untracked,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Verify() = %v, want %v", got, want)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(one, later, later); err != nil {
+		t.Fatal(err)
+	}
+	problems, _, _ = Verify(dir)
+	for _, p := range problems {
+		if p.Path == one && p.Kind != Modified {
+			t.Errorf("edited file reported as %s, want %s", p.Kind, Modified)
+		}
+	}
+}
+
+func TestFindFolders(t *testing.T) {
+	dir := album(t)
+	if got := Find(filepath.Join(dir, "Disc 2", "01 Two.m4a")); got != dir {
+		t.Errorf("Find() from a disc folder = %q, want %q", got, dir)
+	}
+	if got := Find(filepath.Join(t.TempDir(), "song.m4a")); got != "" {
+		t.Errorf("Find() without a manifest = %q", got)
+	}
+	write(t, filepath.Join(dir, "Disc 2", FileName), `{"version":1}`)
+	dirs, err := Folders(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(dirs)
+	if want := []string{dir, filepath.Join(dir, "Disc 2")}; !reflect.DeepEqual(dirs, want) {
+		t.Errorf("Folders() = %v, want %v", dirs, want)
+	}
+}
+
+func TestLoadBroken(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, FileName), "{")
+	if _, err := Load(dir); err == nil {
+		t.Error("Load() accepted a broken manifest")
+	}
+}
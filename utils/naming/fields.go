@@ -0,0 +1,97 @@
+package naming
+
+// Data is the value templates are executed against. Callers fill in the
+// parts that apply; the rest stays zero. The Secondary names are those of
+// secondary-language, or repeat the primary names when it is not set.
+type Data struct {
+	Artist   Artist
+	Album    Album
+	Playlist Playlist
+	Track    Track
+
+	Quality string
+	Codec   string
+	Tag     string
+}
+
+type Artist struct {
+	ID            string
+	Name          string
+	SecondaryName string
+	URLName       string // name of the artist page the download started from
+}
+
+type Album struct {
+	ID                  string
+	Name                string
+	SecondaryName       string
+	ArtistName          string
+	SecondaryArtistName string
+	ReleaseDate         string
+	UPC                 string
+	RecordLabel         string
+	Copyright           string
+	Genre               string
+	ContentRating       string
+	TrackCount          int
+	DiscTotal           int
+	IsCompilation       bool
+	IsSingle            bool
+}
+
+type Playlist struct {
+	ID          string
+	Name        string
+	CuratorName string
+	TrackCount  int
+}
+
+type Track struct {
+	ID                  string
+	Name                string
+	SecondaryName       string
+	ArtistName          string
+	SecondaryArtistName string
+	AlbumName           string
+	SecondaryAlbumName  string
+	ComposerName        string
+	WorkName            string
+	MovementName        string
+	MovementNum         int
+	MovementTotal       int
+	Attribution         string
+	ISRC                string
+	ReleaseDate         string
+	Genre               string
+	ContentRating       string
+	Position            string // {SongNumer}: padded TaskNum, or disc-aware TrackNumber
+	TaskNum             int    // position in the album, playlist or station being ripped
+	TaskTotal           int
+	TrackNumber         int
+	DiscNumber          int
+	DiscTotal           int
+	DurationMs          int
+}
+
+// Sample returns data with every field set, used to validate templates.
+func Sample() Data {
+	return Data{
+		Artist: Artist{ID: "1", Name: "Artist", SecondaryName: "Artist", URLName: "Artist"},
+		Album: Album{
+			ID: "1", Name: "Album", SecondaryName: "Album", ArtistName: "Artist", SecondaryArtistName: "Artist", ReleaseDate: "2000-01-01",
+			UPC: "000000000000", RecordLabel: "Label", Copyright: "(P) 2000 Label",
+			Genre: "Pop", ContentRating: "explicit", TrackCount: 12, DiscTotal: 2,
+		},
+		Playlist: Playlist{ID: "pl.1", Name: "Playlist", CuratorName: "Apple Music", TrackCount: 12},
+		Track: Track{
+			ID: "1", Name: "Song", SecondaryName: "Song", ArtistName: "Artist", SecondaryArtistName: "Artist",
+			AlbumName: "Album", SecondaryAlbumName: "Album", ComposerName: "Composer",
+			WorkName: "Symphony No. 5", MovementName: "Allegro", MovementNum: 1, MovementTotal: 4, Attribution: "Beethoven",
+			ISRC: "USAAA0000001", ReleaseDate: "2000-01-01", Genre: "Pop", ContentRating: "explicit",
+			Position: "01", TaskNum: 1, TaskTotal: 12, TrackNumber: 1, DiscNumber: 1, DiscTotal: 2, DurationMs: 180000,
+		},
+		Quality: "24B-96.0kHz",
+		Codec:   "ALAC",
+		Tag:     "[E]",
+	}
+}
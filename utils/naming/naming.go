@@ -0,0 +1,272 @@
+package naming
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode"
+)
+
+// Options configures the functions available to templates.
+type Options struct {
+	LimitMax int // rune limit applied by the "limit" function
+}
+
+// Template is a compiled folder or file name format.
+type Template struct {
+	t      *template.Template
+	fields map[string]bool // field names the template refers to
+}
+
+var (
+	// A format is a text/template when it has an action starting with a
+	// field, variable, keyword or function; anything else is a legacy format.
+	templateAction = regexp.MustCompile(`\{\{-?\s*[.$a-z(/]`)
+	legacyToken    = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+// legacyTokens maps the original {Token} placeholders to template actions.
+var legacyTokens = map[string]string{
+	"ArtistId":      "{{.Artist.ID}}",
+	"ArtistName":    "{{limit .Artist.Name}}",
+	"UrlArtistName": "{{limit .Artist.URLName}}",
+	"AlbumId":       "{{.Album.ID}}",
+	"AlbumName":     "{{limit .Album.Name}}",
+	"ReleaseDate":   "{{.Album.ReleaseDate}}",
+	"ReleaseYear":   "{{year .Album.ReleaseDate}}",
+	"UPC":           "{{.Album.UPC}}",
+	"RecordLabel":   "{{.Album.RecordLabel}}",
+	"Copyright":     "{{.Album.Copyright}}",
+	"PlaylistId":    "{{.Playlist.ID}}",
+	"PlaylistName":  "{{limit .Playlist.Name}}",
+	"SongId":        "{{.Track.ID}}",
+	"SongNumer":     "{{.Track.Position}}",
+	"SongName":      "{{limit .Track.Name}}",
+	"DiscNumber":    "{{.Track.DiscNumber}}",
+	"TrackNumber":   "{{.Track.TrackNumber}}",
+	"WorkName":      "{{limit .Track.WorkName}}",
+	"MovementName":  "{{limit .Track.MovementName}}",
+	"MovementNum":   "{{.Track.MovementNum}}",
+	"Quality":       "{{.Quality}}",
+	"Codec":         "{{.Codec}}",
+	"Tag":           "{{.Tag}}",
+}
+
+// Parse compiles format, translating legacy {Token} formats first, and
+// validates it by executing it against Sample data.
+func Parse(name, format string, opts Options) (*Template, error) {
+	src := format
+	if !templateAction.MatchString(format) {
+		src = fromLegacy(format)
+	}
+	t, err := template.New(name).Option("missingkey=error").Funcs(funcs(opts)).Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	tmpl := &Template{t: t, fields: map[string]bool{}}
+	for _, def := range t.Templates() {
+		if def.Tree != nil {
+			collectFields(def.Tree.Root, tmpl.fields)
+		}
+	}
+	if _, err := tmpl.Execute(Sample()); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// Execute renders the template. The result is not sanitized for the filesystem.
+func (t *Template) Execute(d Data) (string, error) {
+	var buf bytes.Buffer
+	if err := t.t.Execute(&buf, d); err != nil {
+		return "", fmt.Errorf("%s: %w", t.t.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// ExecuteOr renders the template, printing the error and returning fallback
+// when that fails. Templates are validated by Parse, so this only happens on
+// unusual data; callers pass a name built from IDs so the file still lands
+// somewhere predictable.
+func (t *Template) ExecuteOr(d Data, fallback string) string {
+	s, err := t.Execute(d)
+	if err != nil {
+		fmt.Printf("%v, using %q instead\n", err, fallback)
+		return fallback
+	}
+	return s
+}
+
+// Uses reports whether the template refers to any of the given fields, e.g.
+// "Quality" or "SecondaryName", so callers can skip expensive lookups. Only
+// field references count, not text that merely contains the name.
+func (t *Template) Uses(fields ...string) bool {
+	for _, f := range fields {
+		if t.fields[f] {
+			return true
+		}
+	}
+	return false
+}
+
+// collectFields adds the name of every field referenced below n to fields;
+// .Album.Name adds both Album and Name.
+func collectFields(n parse.Node, fields map[string]bool) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			collectFields(c, fields)
+		}
+	case *parse.ActionNode:
+		collectFields(n.Pipe, fields)
+	case *parse.IfNode:
+		collectBranch(&n.BranchNode, fields)
+	case *parse.RangeNode:
+		collectBranch(&n.BranchNode, fields)
+	case *parse.WithNode:
+		collectBranch(&n.BranchNode, fields)
+	case *parse.TemplateNode:
+		collectFields(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectFields(cmd, fields)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectFields(arg, fields)
+		}
+	case *parse.FieldNode:
+		for _, id := range n.Ident {
+			fields[id] = true
+		}
+	case *parse.ChainNode:
+		collectFields(n.Node, fields)
+		for _, id := range n.Field {
+			fields[id] = true
+		}
+	case *parse.VariableNode:
+		for _, id := range n.Ident[1:] {
+			fields[id] = true
+		}
+	}
+}
+
+func collectBranch(n *parse.BranchNode, fields map[string]bool) {
+	collectFields(n.Pipe, fields)
+	collectFields(n.List, fields)
+	collectFields(n.ElseList, fields)
+}
+
+func fromLegacy(format string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range legacyToken.FindAllStringSubmatchIndex(format, -1) {
+		action, ok := legacyTokens[format[m[2]:m[3]]]
+		if !ok {
+			continue
+		}
+		b.WriteString(escapeText(format[last:m[0]]))
+		b.WriteString(action)
+		last = m[1]
+	}
+	b.WriteString(escapeText(format[last:]))
+	return b.String()
+}
+
+// escapeText keeps literal braces next to an action from being read as
+// part of it, e.g. the "{" in "{{Tag}}".
+func escapeText(s string) string {
+	if !strings.ContainsAny(s, "{}") {
+		return s
+	}
+	return "{{" + strconv.Quote(s) + "}}"
+}
+
+func funcs(opts Options) template.FuncMap {
+	return template.FuncMap{
+		"pad":      pad,
+		"truncate": truncate,
+		"limit": func(s string) string {
+			if opts.LimitMax <= 0 {
+				return s
+			}
+			return truncate(opts.LimitMax, s)
+		},
+		"upper":   strings.ToUpper,
+		"lower":   strings.ToLower,
+		"title":   title,
+		"trim":    strings.TrimSpace,
+		"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"join":    func(sep string, list []string) string { return strings.Join(list, sep) },
+		"date":    date,
+		"year":    func(s string) string { return date("2006", s) },
+		"default": func(def string, v interface{}) string {
+			s := fmt.Sprint(v)
+			if s == "" || s == "0" || s == "false" {
+				return def
+			}
+			return s
+		},
+		"ifelse": func(cond bool, a, b interface{}) interface{} {
+			if cond {
+				return a
+			}
+			return b
+		},
+	}
+}
+
+// pad zero-pads an integer, or a string holding one, to width runes.
+func pad(width int, v interface{}) (string, error) {
+	switch n := v.(type) {
+	case int:
+		return fmt.Sprintf("%0*d", width, n), nil
+	case string:
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return "", fmt.Errorf("pad: %q is not a number", n)
+		}
+		return fmt.Sprintf("%0*d", width, i), nil
+	}
+	return "", fmt.Errorf("pad: unsupported type %T", v)
+}
+
+func truncate(n int, s string) string {
+	r := []rune(s)
+	if n < 0 || len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
+
+func title(s string) string {
+	r := []rune(s)
+	for i := range r {
+		if i == 0 || unicode.IsSpace(r[i-1]) {
+			r[i] = unicode.ToUpper(r[i])
+		}
+	}
+	return string(r)
+}
+
+// date reformats an Apple release date ("2006-01-02", "2006-01" or "2006")
+// using a Go time layout. Unparseable dates are returned unchanged.
+func date(layout, s string) string {
+	for _, in := range []string{"2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(in, s); err == nil {
+			return t.Format(layout)
+		}
+	}
+	return s
+}
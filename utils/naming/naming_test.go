@@ -0,0 +1,96 @@
+package naming
+
+import "testing"
+
+func TestParseExecute(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		opts   Options
+		want   string
+	}{
+		{"legacy", "{AlbumName} [{AlbumId}]", Options{}, "Album [1]"},
+		{"legacy unknown token", "{AlbumName} {Nope}", Options{}, "Album {Nope}"},
+		{"legacy braces", "{{Tag}} {SongNumer}", Options{}, "{[E]} 01"},
+		{"legacy limit", "{ArtistName}", Options{LimitMax: 3}, "Art"},
+		{"template", "{{.Track.DiscNumber}}-{{pad 2 .Track.TrackNumber}} {{.Track.Name}}", Options{}, "1-01 Song"},
+		{"year", "{{year .Album.ReleaseDate}}", Options{}, "2000"},
+		{"date", `{{date "Jan 2006" .Album.ReleaseDate}}`, Options{}, "Jan 2000"},
+		{"default", `{{default "none" .Album.IsSingle}}`, Options{}, "none"},
+		{"ifelse", `{{ifelse .Album.IsCompilation "VA" .Album.ArtistName}}`, Options{}, "Artist"},
+		{"funcs", `{{upper .Codec}} {{title "a b"}} {{replace "-" "." .Album.ReleaseDate}}`, Options{}, "ALAC A B 2000.01.01"},
+		{"truncate", `{{truncate 2 .Album.Name}}`, Options{}, "Al"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.name, tt.format, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tmpl.Execute(Sample())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Execute() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, format := range []string{
+		"{{.Album.Nope}}",
+		"{{.Album.Name",
+		"{{pad 2 .Album.Name}}",
+		"{{nope .Album.Name}}",
+	} {
+		if _, err := Parse("test", format, Options{}); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", format)
+		}
+	}
+}
+
+func TestExecuteOr(t *testing.T) {
+	tmpl, err := Parse("test", "{{pad 2 .Track.Position}} {{.Track.Name}}", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Sample()
+	if got := tmpl.ExecuteOr(d, "1"); got != "01 Song" {
+		t.Errorf("ExecuteOr() = %q, want %q", got, "01 Song")
+	}
+	d.Track.Position = "x"
+	if got := tmpl.ExecuteOr(d, "1"); got != "1" {
+		t.Errorf("ExecuteOr() with failing data = %q, want the fallback", got)
+	}
+}
+
+func TestUses(t *testing.T) {
+	tests := []struct {
+		format string
+		field  string
+		want   bool
+	}{
+		{"{{.Album.Name}} {{.Quality}}", "Quality", true},
+		{"{Quality}", "Quality", true},
+		{"{{.Album.Name}}", "Album", true},
+		{"{{.Album.Name}}", "Quality", false},
+		{`{{.Album.Name}} {{"Quality"}}`, "Quality", false},
+		{`{{/* Quality */}}{{.Album.Name}}`, "Quality", false},
+		{"Quality {{.Album.Name}}", "Quality", false},
+		{"{{if .Album.IsSingle}}{{.Track.Name}}{{else}}{{.Quality}}{{end}}", "Quality", true},
+		{"{{with .Album}}{{.SecondaryName}}{{end}}", "SecondaryName", true},
+		{`{{$q := .Quality}}{{$q}}`, "Quality", true},
+		{"{{.Album.SecondaryName}}", "Secondary", false},
+	}
+	for _, tt := range tests {
+		tmpl, err := Parse("test", tt.format, Options{})
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.format, err)
+		}
+		if got := tmpl.Uses(tt.field); got != tt.want {
+			t.Errorf("Parse(%q).Uses(%q) = %v, want %v", tt.format, tt.field, got, tt.want)
+		}
+	}
+}
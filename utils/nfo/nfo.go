@@ -0,0 +1,176 @@
+// Package nfo writes the album.nfo and artist.nfo sidecar files read by
+// Kodi, Jellyfin and Plex (through its XBMCnfo agents).
+package nfo
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+)
+
+// Album describes an album. ReleaseDate is YYYY-MM-DD; Thumb is the cover
+// file name relative to the album folder.
+type Album struct {
+	Title       string
+	Artist      string
+	Genres      []string
+	Label       string
+	ReleaseDate string
+	Compilation bool
+	Single      bool
+	Copyright   string
+	UPC         string
+	AppleID     string
+	Review      string
+	Thumb       string
+	Tracks      []Track
+}
+
+// Track is one entry of an album's track list.
+type Track struct {
+	Disc       int
+	Position   int
+	Title      string
+	DurationMs int
+}
+
+// Artist describes an artist. Thumb is the image file name relative to the
+// artist folder.
+type Artist struct {
+	Name      string
+	AppleID   string
+	Genres    []string
+	Biography string
+	Thumb     string
+}
+
+type thumb struct {
+	Aspect string `xml:"aspect,attr"`
+	Path   string `xml:",chardata"`
+}
+
+type uniqueID struct {
+	Type string `xml:"type,attr"`
+	ID   string `xml:",chardata"`
+}
+
+type albumTrack struct {
+	Disc     int    `xml:"disc,omitempty"`
+	Position int    `xml:"position"`
+	Title    string `xml:"title"`
+	Duration string `xml:"duration,omitempty"`
+}
+
+type albumDoc struct {
+	XMLName     xml.Name     `xml:"album"`
+	Title       string       `xml:"title"`
+	Artist      string       `xml:"artist,omitempty"`
+	AlbumArtist string       `xml:"albumartist,omitempty"`
+	Credits     []string     `xml:"albumArtistCredits>artist,omitempty"`
+	Genres      []string     `xml:"genre"`
+	ReleaseType string       `xml:"releasetype"`
+	Compilation bool         `xml:"compilation"`
+	Review      string       `xml:"review,omitempty"`
+	Label       string       `xml:"label,omitempty"`
+	Studio      string       `xml:"studio,omitempty"`
+	ReleaseDate string       `xml:"releasedate,omitempty"`
+	Premiered   string       `xml:"premiered,omitempty"`
+	Year        string       `xml:"year,omitempty"`
+	Copyright   string       `xml:"copyright,omitempty"`
+	UniqueIDs   []uniqueID   `xml:"uniqueid"`
+	Thumb       *thumb       `xml:"thumb,omitempty"`
+	Tracks      []albumTrack `xml:"track"`
+}
+
+type artistDoc struct {
+	XMLName   xml.Name   `xml:"artist"`
+	Name      string     `xml:"name"`
+	Genres    []string   `xml:"genre"`
+	Biography string     `xml:"biography,omitempty"`
+	UniqueIDs []uniqueID `xml:"uniqueid"`
+	Thumb     *thumb     `xml:"thumb,omitempty"`
+}
+
+// WriteAlbum writes a as an album.nfo document to path.
+func WriteAlbum(path string, a Album) error {
+	doc := albumDoc{
+		Title:       a.Title,
+		Artist:      a.Artist,
+		AlbumArtist: a.Artist,
+		Genres:      a.Genres,
+		ReleaseType: "album",
+		Compilation: a.Compilation,
+		Review:      a.Review,
+		Label:       a.Label,
+		Studio:      a.Label,
+		ReleaseDate: a.ReleaseDate,
+		Premiered:   a.ReleaseDate,
+		Copyright:   a.Copyright,
+	}
+	if a.Artist != "" {
+		doc.Credits = []string{a.Artist}
+	}
+	if a.Single {
+		doc.ReleaseType = "single"
+	}
+	if len(a.ReleaseDate) >= 4 {
+		doc.Year = a.ReleaseDate[:4]
+	}
+	if a.AppleID != "" {
+		doc.UniqueIDs = append(doc.UniqueIDs, uniqueID{Type: "applemusic", ID: a.AppleID})
+	}
+	if a.UPC != "" {
+		doc.UniqueIDs = append(doc.UniqueIDs, uniqueID{Type: "upc", ID: a.UPC})
+	}
+	if a.Thumb != "" {
+		doc.Thumb = &thumb{Aspect: "thumb", Path: a.Thumb}
+	}
+	multiDisc := false
+	for _, t := range a.Tracks {
+		if t.Disc > 1 {
+			multiDisc = true
+		}
+	}
+	for _, t := range a.Tracks {
+		track := albumTrack{Position: t.Position, Title: t.Title, Duration: duration(t.DurationMs)}
+		if multiDisc {
+			track.Disc = t.Disc
+		}
+		doc.Tracks = append(doc.Tracks, track)
+	}
+	return write(path, doc)
+}
+
+// WriteArtist writes a as an artist.nfo document to path.
+func WriteArtist(path string, a Artist) error {
+	doc := artistDoc{
+		Name:      a.Name,
+		Genres:    a.Genres,
+		Biography: a.Biography,
+	}
+	if a.AppleID != "" {
+		doc.UniqueIDs = append(doc.UniqueIDs, uniqueID{Type: "applemusic", ID: a.AppleID})
+	}
+	if a.Thumb != "" {
+		doc.Thumb = &thumb{Aspect: "thumb", Path: a.Thumb}
+	}
+	return write(path, doc)
+}
+
+// duration formats milliseconds as m:ss, the form Kodi shows.
+func duration(ms int) string {
+	if ms <= 0 {
+		return ""
+	}
+	secs := (ms + 500) / 1000
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+func write(path string, doc interface{}) error {
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n"), data...)
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
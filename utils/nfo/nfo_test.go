@@ -0,0 +1,114 @@
+package nfo
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares the file written at path to testdata/name.
+func golden(t *testing.T, path, name string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(want, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	data, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("%s =\n%s\nwant\n%s", name, got, data)
+	}
+}
+
+func TestWriteAlbum(t *testing.T) {
+	tests := []struct {
+		golden string
+		album  Album
+	}{
+		{"single.nfo", Album{
+			Title:       "Song & Dance - Single",
+			Artist:      "Artist",
+			Genres:      []string{"Pop"},
+			ReleaseDate: "2024-05-01",
+			Single:      true,
+			AppleID:     "1234567890",
+			Thumb:       "cover.jpg",
+			// single-disc albums list no disc numbers
+			Tracks: []Track{{Disc: 1, Position: 1, Title: "Song & Dance", DurationMs: 201499}},
+		}},
+		{"multidisc.nfo", Album{
+			Title:       "Box Set",
+			Artist:      "Various Artists",
+			Genres:      []string{"Rock", "Classic Rock"},
+			Label:       "Label",
+			ReleaseDate: "1999-12-31",
+			Compilation: true,
+			Copyright:   "℗ 1999 Label",
+			UPC:         "00602537123456",
+			AppleID:     "42",
+			Review:      "<p>Remastered.</p>",
+			Tracks: []Track{
+				{Disc: 1, Position: 1, Title: "Opener", DurationMs: 59500},
+				{Disc: 1, Position: 2, Title: "Unknown length"},
+				{Disc: 2, Position: 1, Title: "Long one", DurationMs: 3600000},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "album.nfo")
+			if err := WriteAlbum(path, tt.album); err != nil {
+				t.Fatal(err)
+			}
+			golden(t, path, tt.golden)
+		})
+	}
+}
+
+func TestWriteArtist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artist.nfo")
+	err := WriteArtist(path, Artist{
+		Name:      "AC/DC",
+		AppleID:   "5040714",
+		Genres:    []string{"Hard Rock"},
+		Biography: "Formed in Sydney.",
+		Thumb:     "folder.jpg",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, path, "artist.nfo")
+}
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		ms   int
+		want string
+	}{
+		{0, ""},
+		{-1, ""},
+		{499, "0:00"},
+		{500, "0:01"},
+		{59499, "0:59"},
+		{59500, "1:00"},
+		{201499, "3:21"},
+		{3600000, "60:00"},
+	}
+	for _, tt := range tests {
+		if got := duration(tt.ms); got != tt.want {
+			t.Errorf("duration(%d) = %q, want %q", tt.ms, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package playlistfile writes playlists that reference tracks stored
+// elsewhere in the library, as M3U8, XSPF or JSPF.
+package playlistfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Formats lists the supported playlist formats, which are also the file
+// extensions written.
+var Formats = []string{"m3u8", "xspf", "jspf"}
+
+// Playlist is a titled, ordered list of entries.
+type Playlist struct {
+	Title   string
+	Curator string
+	Entries []Entry
+}
+
+// Entry is one track. Path is the track's absolute or working-directory
+// relative path; it is made relative to the playlist file when written.
+type Entry struct {
+	Path       string
+	Title      string
+	Artist     string
+	Album      string
+	DurationMs int
+}
+
+// Write saves p next to base (a path without extension) in each format and
+// returns the files written.
+func Write(base string, p Playlist, formats []string) ([]string, error) {
+	var written []string
+	for _, format := range formats {
+		var data []byte
+		var err error
+		out := base + "." + format
+		switch format {
+		case "m3u8":
+			data, err = M3U8(out, p)
+		case "xspf":
+			data, err = XSPF(out, p)
+		case "jspf":
+			data, err = JSPF(out, p)
+		default:
+			err = fmt.Errorf("unknown playlist format %q", format)
+		}
+		if err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(out, data, 0644); err != nil {
+			return written, err
+		}
+		written = append(written, out)
+	}
+	return written, nil
+}
+
+// Valid reports whether format is one of Formats.
+func Valid(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// relative returns target relative to the directory of the playlist file,
+// using forward slashes, which every player accepts.
+func relative(playlist, target string) (string, error) {
+	from, err := filepath.Abs(filepath.Dir(playlist))
+	if err != nil {
+		return "", err
+	}
+	to, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(from, to)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// M3U8 renders an extended M3U playlist in UTF-8.
+func M3U8(out string, p Playlist) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	if p.Title != "" {
+		fmt.Fprintf(&b, "#PLAYLIST:%s\n", oneLine(p.Title))
+	}
+	for _, e := range p.Entries {
+		rel, err := relative(out, e.Path)
+		if err != nil {
+			return nil, err
+		}
+		secs := -1
+		if e.DurationMs > 0 {
+			secs = (e.DurationMs + 500) / 1000
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s - %s\n", secs, oneLine(e.Artist), oneLine(e.Title))
+		if e.Album != "" {
+			fmt.Fprintf(&b, "#EXTALB:%s\n", oneLine(e.Album))
+		}
+		b.WriteString(rel + "\n")
+	}
+	return b.Bytes(), nil
+}
+
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Duration int    `xml:"duration,omitempty"`
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Creator string      `xml:"creator,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+// XSPF renders an XML Shareable Playlist Format document.
+func XSPF(out string, p Playlist) ([]byte, error) {
+	doc := xspfPlaylist{Version: "1", Xmlns: "http://xspf.org/ns/0/", Title: p.Title, Creator: p.Curator}
+	for _, e := range p.Entries {
+		rel, err := relative(out, e.Path)
+		if err != nil {
+			return nil, err
+		}
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Location: escapeLocation(rel),
+			Title:    e.Title,
+			Creator:  e.Artist,
+			Album:    e.Album,
+			Duration: e.DurationMs,
+		})
+	}
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+type jspfTrack struct {
+	Location []string `json:"location"`
+	Title    string   `json:"title,omitempty"`
+	Creator  string   `json:"creator,omitempty"`
+	Album    string   `json:"album,omitempty"`
+	Duration int      `json:"duration,omitempty"`
+}
+
+// JSPF renders the JSON form of XSPF.
+func JSPF(out string, p Playlist) ([]byte, error) {
+	tracks := []jspfTrack{}
+	for _, e := range p.Entries {
+		rel, err := relative(out, e.Path)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, jspfTrack{
+			Location: []string{escapeLocation(rel)},
+			Title:    e.Title,
+			Creator:  e.Artist,
+			Album:    e.Album,
+			Duration: e.DurationMs,
+		})
+	}
+	doc := map[string]interface{}{
+		"playlist": map[string]interface{}{
+			"title":   p.Title,
+			"creator": p.Curator,
+			"track":   tracks,
+		},
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// escapeLocation percent-encodes a relative path for use as an XSPF
+// location URI, keeping the separators.
+func escapeLocation(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
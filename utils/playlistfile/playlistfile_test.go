@@ -0,0 +1,116 @@
+package playlistfile
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testPlaylist(root string) Playlist {
+	return Playlist{
+		Title:   "Mix\nTape",
+		Curator: "Apple Music",
+		Entries: []Entry{
+			{Path: filepath.Join(root, "Artist", "Album", "01 Song.m4a"), Title: "Song", Artist: "Artist", Album: "Album", DurationMs: 180400},
+			{Path: filepath.Join(root, "Other", "Disc #1", "02 100% & More.m4a"), Title: "100% & More", Artist: "Other"},
+		},
+	}
+}
+
+func TestM3U8(t *testing.T) {
+	root := t.TempDir()
+	data, err := M3U8(filepath.Join(root, "Playlists", "Mix.m3u8"), testPlaylist(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"#EXTM3U",
+		"#PLAYLIST:Mix Tape",
+		"#EXTINF:180,Artist - Song",
+		"#EXTALB:Album",
+		"../Artist/Album/01 Song.m4a",
+		"#EXTINF:-1,Other - 100% & More",
+		"../Other/Disc #1/02 100% & More.m4a",
+		"",
+	}, "\n")
+	if string(data) != want {
+		t.Errorf("M3U8() =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestXSPF(t *testing.T) {
+	root := t.TempDir()
+	data, err := XSPF(filepath.Join(root, "Mix.xspf"), testPlaylist(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc xspfPlaylist
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Tracks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(doc.Tracks))
+	}
+	if got, want := doc.Tracks[1].Location, "Other/Disc%20%231/02%20100%25%20&%20More.m4a"; got != want {
+		t.Errorf("location = %q, want %q", got, want)
+	}
+	if doc.Tracks[0].Duration != 180400 || doc.Tracks[0].Creator != "Artist" {
+		t.Errorf("track = %+v", doc.Tracks[0])
+	}
+}
+
+func TestJSPF(t *testing.T) {
+	root := t.TempDir()
+	data, err := JSPF(filepath.Join(root, "Mix.jspf"), Playlist{Title: "Empty"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Playlist struct {
+			Title string
+			Track []jspfTrack
+		}
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Playlist.Title != "Empty" || doc.Playlist.Track == nil {
+		t.Errorf("JSPF() = %s, want an empty track list", data)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	root := t.TempDir()
+	base := filepath.Join(root, "Mix")
+	written, err := Write(base, testPlaylist(root), Formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != len(Formats) {
+		t.Fatalf("Write() wrote %v", written)
+	}
+	for _, f := range written {
+		if _, err := os.Stat(f); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := Write(base, testPlaylist(root), []string{"m3u8", "pls"}); err == nil {
+		t.Error("Write() accepted an unknown format")
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, f := range []string{"m3u8", "xspf", "jspf"} {
+		if !Valid(f) {
+			t.Errorf("Valid(%q) = false", f)
+		}
+	}
+	for _, f := range []string{"", "m3u", "M3U8", "pls"} {
+		if Valid(f) {
+			t.Errorf("Valid(%q) = true", f)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Package playlistsync remembers the tracks of a playlist between runs and
+// works out what changed, so a synced copy can follow the playlist.
+package playlistsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Track is a playlist entry as last saved.
+type Track struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Artist string `json:"artist"`
+	Path   string `json:"path,omitempty"`
+}
+
+// State is the last-seen track list of one playlist.
+type State struct {
+	ID     string    `json:"id"`
+	Name   string    `json:"name"`
+	Synced time.Time `json:"synced"`
+	Tracks []Track   `json:"tracks"`
+}
+
+func statePath(dir, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+// Load reads the state saved for playlist id, or returns nil if the
+// playlist has not been synced before.
+func Load(dir, id string) (*State, error) {
+	data, err := os.ReadFile(statePath(dir, id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := new(State)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", statePath(dir, id), err)
+	}
+	return s, nil
+}
+
+// Save writes the state to dir, replacing the previous one.
+func (s *State) Save(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := statePath(dir, s.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath(dir, s.ID))
+}
+
+// Move is a track whose position changed; positions are 1-based.
+type Move struct {
+	Track    Track
+	From, To int
+}
+
+// Changes is the difference between the saved and the current track list.
+type Changes struct {
+	Added   []Track
+	Removed []Track
+	Moved   []Move
+}
+
+// Empty reports whether nothing changed.
+func (c Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Moved) == 0
+}
+
+// Diff compares the saved state, which may be nil, with the current tracks.
+// Removed tracks keep the path they were saved under.
+func Diff(old *State, current []Track) Changes {
+	var c Changes
+	before := make(map[string]int)
+	if old != nil {
+		for i, t := range old.Tracks {
+			before[t.ID] = i + 1
+		}
+	}
+	now := make(map[string]bool)
+	for i, t := range current {
+		now[t.ID] = true
+		from, ok := before[t.ID]
+		switch {
+		case !ok:
+			c.Added = append(c.Added, t)
+		case from != i+1:
+			c.Moved = append(c.Moved, Move{Track: t, From: from, To: i + 1})
+		}
+	}
+	if old != nil {
+		for _, t := range old.Tracks {
+			if !now[t.ID] {
+				c.Removed = append(c.Removed, t)
+			}
+		}
+	}
+	return c
+}
+
+// AppendLog adds a dated section describing c to the changelog at path.
+func (c Changes) AppendLog(path, playlist string, at time.Time) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "== %s  %s\n", at.Format("2006-01-02 15:04:05"), playlist)
+	if c.Empty() {
+		b.WriteString("No changes\n")
+	}
+	for _, t := range c.Added {
+		fmt.Fprintf(&b, "+ %s - %s\n", t.Artist, t.Name)
+	}
+	for _, t := range c.Removed {
+		fmt.Fprintf(&b, "- %s - %s\n", t.Artist, t.Name)
+	}
+	for _, m := range c.Moved {
+		fmt.Fprintf(&b, "~ %s - %s (%d -> %d)\n", m.Track.Artist, m.Track.Name, m.From, m.To)
+	}
+	b.WriteString("\n")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Rename moves files from their saved paths to their new ones, for tracks
+// whose file name changed because the playlist was reordered. Files are
+// moved through temporary names so that swapped tracks do not overwrite
+// each other; a file that cannot reach its new name is moved back when its
+// old name is still free, so no temporary names are left behind. sidecars
+// are extensions (".lrc") of files moved alongside.
+func Rename(old *State, current []Track, sidecars ...string) error {
+	if old == nil {
+		return nil
+	}
+	saved := make(map[string]string)
+	for _, t := range old.Tracks {
+		saved[t.ID] = t.Path
+	}
+	type move struct{ from, tmp, to string }
+	var moves []move
+	for _, t := range current {
+		from := saved[t.ID]
+		if from == "" || t.Path == "" || from == t.Path {
+			continue
+		}
+		exts := append([]string{filepath.Ext(from)}, sidecars...)
+		for _, ext := range exts {
+			src := strings.TrimSuffix(from, filepath.Ext(from)) + ext
+			if _, err := os.Stat(src); err != nil {
+				continue
+			}
+			dst := strings.TrimSuffix(t.Path, filepath.Ext(t.Path)) + ext
+			moves = append(moves, move{from: src, tmp: src + ".sync-tmp", to: dst})
+		}
+	}
+	for i, m := range moves {
+		if err := os.Rename(m.from, m.tmp); err != nil {
+			for _, done := range moves[:i] {
+				os.Rename(done.tmp, done.from)
+			}
+			return err
+		}
+	}
+	var errs []error
+	for _, m := range moves {
+		err := os.MkdirAll(filepath.Dir(m.to), os.ModePerm)
+		if err == nil {
+			err = os.Rename(m.tmp, m.to)
+		}
+		if err == nil {
+			continue
+		}
+		errs = append(errs, err)
+		if _, statErr := os.Lstat(m.from); errors.Is(statErr, fs.ErrNotExist) {
+			err = os.Rename(m.tmp, m.from)
+		} else {
+			err = fmt.Errorf("%s is taken, %s left in place", m.from, m.tmp)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Archive moves the files of removed tracks saved under root into
+// root/archive, keeping their names, and returns the files moved.
+// Files outside root, such as library tracks shared with albums, are left
+// alone.
+func Archive(removed []Track, root, archive string, sidecars ...string) ([]string, error) {
+	var moved []string
+	prefix := filepath.Clean(root) + string(filepath.Separator)
+	for _, t := range removed {
+		if t.Path == "" || !strings.HasPrefix(filepath.Clean(t.Path), prefix) {
+			continue
+		}
+		exts := append([]string{filepath.Ext(t.Path)}, sidecars...)
+		for _, ext := range exts {
+			src := strings.TrimSuffix(t.Path, filepath.Ext(t.Path)) + ext
+			if _, err := os.Stat(src); err != nil {
+				continue
+			}
+			dst := filepath.Join(root, archive, filepath.Base(src))
+			if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+				return moved, err
+			}
+			if err := os.Rename(src, dst); err != nil {
+				return moved, err
+			}
+			moved = append(moved, dst)
+		}
+	}
+	return moved, nil
+}
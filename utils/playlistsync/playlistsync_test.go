@@ -0,0 +1,182 @@
+package playlistsync
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func write(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestLoadSave(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Load(dir, "pl.1")
+	if err != nil || s != nil {
+		t.Fatalf("Load() of an unsynced playlist = %v, %v", s, err)
+	}
+	want := &State{ID: "pl.1", Name: "Mix", Synced: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Tracks: []Track{{ID: "1", Name: "Song", Artist: "Artist", Path: "01 Song.m4a"}}}
+	if err := want.Save(filepath.Join(dir, "state")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(filepath.Join(dir, "state"), "pl.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %+v, want %+v", got, want)
+	}
+	write(t, filepath.Join(dir, "pl.2.json"), "{")
+	if _, err := Load(dir, "pl.2"); err == nil {
+		t.Error("Load() accepted a broken state file")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a, b, c, d := Track{ID: "a"}, Track{ID: "b"}, Track{ID: "c", Path: "c.m4a"}, Track{ID: "d"}
+	tests := []struct {
+		name    string
+		old     *State
+		current []Track
+		want    Changes
+	}{
+		{"first sync", nil, []Track{a, b}, Changes{Added: []Track{a, b}}},
+		{"unchanged", &State{Tracks: []Track{a, b}}, []Track{a, b}, Changes{}},
+		{"swapped", &State{Tracks: []Track{a, b}}, []Track{b, a}, Changes{Moved: []Move{{b, 2, 1}, {a, 1, 2}}}},
+		{"removed keeps path", &State{Tracks: []Track{a, c}}, []Track{a}, Changes{Removed: []Track{c}}},
+		{"all at once", &State{Tracks: []Track{a, b, c}}, []Track{d, b, a}, Changes{
+			Added:   []Track{d},
+			Removed: []Track{c},
+			Moved:   []Move{{a, 1, 3}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(tt.old, tt.current)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+			if got.Empty() != (tt.name == "unchanged") {
+				t.Errorf("Empty() = %v", got.Empty())
+			}
+		})
+	}
+}
+
+func TestAppendLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changelog.txt")
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := Changes{
+		Added:   []Track{{Name: "New", Artist: "A"}},
+		Removed: []Track{{Name: "Old", Artist: "B"}},
+		Moved:   []Move{{Track: Track{Name: "Song", Artist: "C"}, From: 1, To: 2}},
+	}
+	if err := c.AppendLog(path, "Mix", at); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Changes{}).AppendLog(path, "Mix", at); err != nil {
+		t.Fatal(err)
+	}
+	want := "== 2024-05-01 12:00:00  Mix\n+ A - New\n- B - Old\n~ C - Song (1 -> 2)\n\n" +
+		"== 2024-05-01 12:00:00  Mix\nNo changes\n\n"
+	if got := read(t, path); got != want {
+		t.Errorf("changelog =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenameSwap(t *testing.T) {
+	dir := t.TempDir()
+	one, two := filepath.Join(dir, "01 A.m4a"), filepath.Join(dir, "02 B.m4a")
+	write(t, one, "a")
+	write(t, two, "b")
+	write(t, filepath.Join(dir, "01 A.lrc"), "a lyrics")
+	old := &State{Tracks: []Track{{ID: "a", Path: one}, {ID: "b", Path: two}}}
+	current := []Track{
+		{ID: "b", Path: filepath.Join(dir, "01 B.m4a")},
+		{ID: "a", Path: filepath.Join(dir, "02 A.m4a")},
+		{ID: "c", Path: filepath.Join(dir, "03 C.m4a")},
+	}
+	if err := Rename(old, current, ".lrc"); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"01 B.m4a": "b",
+		"02 A.m4a": "a",
+		"02 A.lrc": "a lyrics",
+	} {
+		if got := read(t, filepath.Join(dir, path)); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("left %d files, want 3", len(entries))
+	}
+}
+
+func TestRenameFailureLeavesNoTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	one, two := filepath.Join(dir, "01 A.m4a"), filepath.Join(dir, "02 B.m4a")
+	write(t, one, "a")
+	write(t, two, "b")
+	write(t, filepath.Join(dir, "blocked"), "")
+	old := &State{Tracks: []Track{{ID: "a", Path: one}, {ID: "b", Path: two}}}
+	current := []Track{
+		{ID: "a", Path: filepath.Join(dir, "blocked", "01 A.m4a")},
+		{ID: "b", Path: filepath.Join(dir, "03 B.m4a")},
+	}
+	if err := Rename(old, current); err == nil {
+		t.Fatal("Rename() into a file succeeded")
+	}
+	if got := read(t, one); got != "a" {
+		t.Errorf("failed move not undone: %s = %q", one, got)
+	}
+	if got := read(t, filepath.Join(dir, "03 B.m4a")); got != "b" {
+		t.Errorf("other move not done: got %q", got)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".sync-tmp") {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "Album", "01 Shared.m4a")
+	inside := filepath.Join(root, "02 Gone.m4a")
+	write(t, inside, "gone")
+	write(t, strings.TrimSuffix(inside, ".m4a")+".lrc", "lyrics")
+	write(t, outside, "shared")
+	moved, err := Archive([]Track{{Path: inside}, {Path: outside}, {ID: "no path"}}, root, "Removed", ".lrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(root, "Removed", "02 Gone.m4a"), filepath.Join(root, "Removed", "02 Gone.lrc")}
+	if !reflect.DeepEqual(moved, want) {
+		t.Errorf("Archive() = %v, want %v", moved, want)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("file outside the playlist folder was moved: %v", err)
+	}
+}
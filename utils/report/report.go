@@ -0,0 +1,75 @@
+// Package report collects the notable events of a download run, such as
+// renamed files, so they can be reviewed after the progress output scrolls by.
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kinds of entries.
+const (
+	Collision = "collision"
+	Upgrade   = "upgrade"
+	Verify    = "verify"
+	Lyrics    = "lyrics"
+)
+
+// Entry is one event. Job is the album, playlist or station ID the track
+// was downloaded for.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Job     string    `json:"job"`
+	TrackID string    `json:"trackId,omitempty"`
+	Path    string    `json:"path,omitempty"`
+	Message string    `json:"message"`
+}
+
+// Report is safe for concurrent use; the zero value is empty and ready.
+type Report struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// Add records e, stamping it with the current time if unset.
+func (r *Report) Add(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	r.mu.Lock()
+	r.entries = append(r.entries, e)
+	r.mu.Unlock()
+}
+
+// Entries returns a copy of the recorded entries in order.
+func (r *Report) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Entry(nil), r.entries...)
+}
+
+// Len returns the number of recorded entries.
+func (r *Report) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.entries)
+}
+
+// Reset drops all entries.
+func (r *Report) Reset() {
+	r.mu.Lock()
+	r.entries = nil
+	r.mu.Unlock()
+}
+
+// WriteJSON writes the entries to path as an indented JSON array.
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r.Entries(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"path/filepath"
 
 	"github.com/gospider007/requests"
 	"google.golang.org/protobuf/proto"
@@ -12,6 +11,7 @@ import (
 	//"log/slog"
 	cdm "main/utils/runv3/cdm"
 	key "main/utils/runv3/key"
+	"main/utils/tools"
 	"os"
 
 	"bytes"
@@ -23,7 +23,6 @@ import (
 	//"io/ioutil"
 	"encoding/json"
 	"net/http"
-	"os/exec"
 	"strings"
 	"sync"
 	//"time"
@@ -493,12 +492,10 @@ func ExtMvData(keyAndUrls string, savePath string) error {
 	}
 	fmt.Println("\nDownloaded.")
 
-	cmd1 := exec.Command("mp4decrypt", "--key", key, tempFile.Name(), filepath.Base(savePath))
-	cmd1.Dir = filepath.Dir(savePath) //设置mp4decrypt的工作目录以解决中文路径错误
-	outlog, err := cmd1.CombinedOutput()
+	//mp4decrypt在输出目录中运行以解决中文路径错误
+	err = tools.Default.Mp4Decrypt.Decrypt(key, tempFile.Name(), savePath)
 	if err != nil {
 		fmt.Printf("Decrypt failed: %v\n", err)
-		fmt.Printf("Output:\n%s\n", outlog)
 		return err
 	} else {
 		fmt.Println("Decrypted.")
@@ -0,0 +1,293 @@
+// Package safepath turns catalog names into file and folder names that are
+// valid on the filesystem the library is written to.
+package safepath
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unit is how a filesystem measures name and path lengths.
+type Unit int
+
+const (
+	Bytes Unit = iota // UTF-8 bytes, as on ext4, btrfs, APFS
+	UTF16             // UTF-16 code units, as on NTFS and FAT32 long names
+)
+
+// Form is the Unicode normalization applied to names.
+type Form string
+
+const (
+	NFC  Form = "nfc"
+	NFD  Form = "nfd"
+	None Form = "none"
+)
+
+// Policy describes the naming rules of one kind of filesystem.
+type Policy struct {
+	ID              string
+	Forbidden       string // characters replaced by Replacement, besides control characters
+	Replacement     string
+	Reserved        bool // reject DOS device names such as CON, NUL and COM1
+	TrimTrailingDot bool // remove dots and spaces at the end of a name
+	CaseInsensitive bool
+	Unit            Unit
+	MaxName         int // per path element, 0 for no limit
+	MaxPath         int // whole path, 0 for no limit
+	Form            Form
+}
+
+var (
+	// Windows matches NTFS with the legacy MAX_PATH limit. It rejects the same
+	// characters the downloader always has, so it is the default everywhere.
+	Windows = Policy{
+		ID:              "windows",
+		Forbidden:       `<>:"/\|?*`,
+		Replacement:     "_",
+		Reserved:        true,
+		TrimTrailingDot: true,
+		CaseInsensitive: true,
+		Unit:            UTF16,
+		MaxName:         255,
+		MaxPath:         260,
+		Form:            NFC,
+	}
+	// POSIX only forbids the separator, for libraries that stay on Linux or macOS.
+	POSIX = Policy{
+		ID:          "posix",
+		Forbidden:   "/",
+		Replacement: "_",
+		Unit:        Bytes,
+		MaxName:     255,
+		Form:        NFC,
+	}
+	// SMB is a Windows share or a Samba share backed by a POSIX filesystem:
+	// Windows characters and names, byte-length limits, no MAX_PATH.
+	SMB = Policy{
+		ID:              "smb",
+		Forbidden:       `<>:"/\|?*`,
+		Replacement:     "_",
+		Reserved:        true,
+		TrimTrailingDot: true,
+		CaseInsensitive: true,
+		Unit:            Bytes,
+		MaxName:         255,
+		Form:            NFC,
+	}
+)
+
+// Policies lists the built-in policies by name. FAT32 long names follow
+// the Windows rules, so "fat32" is an alias for removable media.
+var Policies = map[string]Policy{
+	Windows.ID: Windows,
+	POSIX.ID:   POSIX,
+	SMB.ID:     SMB,
+	"fat32":    Windows,
+}
+
+// Default is the policy used by Name, File and Join.
+var Default = Windows
+
+// Lookup returns the named policy; an empty name selects Windows.
+func Lookup(name string) (Policy, error) {
+	if name == "" {
+		return Windows, nil
+	}
+	p, ok := Policies[strings.ToLower(name)]
+	if !ok {
+		return Policy{}, fmt.Errorf("unknown path policy %q (want windows, posix, smb or fat32)", name)
+	}
+	return p, nil
+}
+
+// WithForm returns a copy of p using the given normalization form.
+func (p Policy) WithForm(f Form) (Policy, error) {
+	switch f {
+	case "":
+		return p, nil
+	case NFC, NFD, None:
+		p.Form = f
+		return p, nil
+	}
+	return p, fmt.Errorf("unknown unicode form %q (want nfc, nfd or none)", f)
+}
+
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Name makes a single folder or file name safe. It never returns an empty
+// string, "." or "..".
+func (p Policy) Name(s string) string {
+	s = p.normalize(s)
+	var b strings.Builder
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(p.Forbidden, r) {
+			b.WriteString(p.Replacement)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	s = p.trim(b.String())
+	if p.MaxName > 0 {
+		s = p.trim(p.truncate(s, p.MaxName))
+	}
+	if p.Reserved {
+		stem := s
+		if i := strings.IndexByte(stem, '.'); i >= 0 {
+			stem = stem[:i]
+		}
+		if reservedNames[strings.ToUpper(strings.TrimSpace(stem))] {
+			s = p.Replacement + s
+		}
+	}
+	if s == "" || s == "." || s == ".." {
+		s = p.Replacement
+	}
+	return s
+}
+
+// File makes base safe and appends ext (".m4a"), keeping ext intact when
+// the name has to be shortened. An ext that leaves no room for the name is
+// cut like any other name.
+func (p Policy) File(base, ext string) string {
+	ext = p.normalize(ext)
+	name := p.Name(base)
+	if p.MaxName <= 0 || p.Len(name+ext) <= p.MaxName {
+		return name + ext
+	}
+	if room := p.MaxName - p.Len(ext); room > 0 {
+		if short := p.trim(p.truncate(name, room)); short != "" {
+			return short + ext
+		}
+	}
+	return p.Name(p.truncate(name+ext, p.MaxName))
+}
+
+// Join joins dir with the sanitized elements. MaxPath applies to the
+// absolute path, as the filesystem sees it, even when dir is relative. When
+// it would be exceeded the elements are shortened, the last one first and
+// keeping its extension, down to one character each; the extension goes
+// last. Only a dir that is too long by itself yields a path over the limit.
+func (p Policy) Join(dir string, elem ...string) string {
+	if len(elem) == 0 {
+		return dir
+	}
+	parts := make([]string, len(elem))
+	for i, e := range elem {
+		parts[i] = p.Name(e)
+	}
+	path := filepath.Join(append([]string{dir}, parts...)...)
+	if p.MaxPath <= 0 {
+		return path
+	}
+	// the room the absolute form of dir takes beyond dir itself
+	limit := p.MaxPath
+	if abs, err := filepath.Abs(dir); err == nil {
+		limit -= p.Len(abs) - p.Len(filepath.Clean(dir))
+	}
+	last := len(parts) - 1
+	ext := filepath.Ext(parts[last])
+	if p.Len(ext) > 8 {
+		ext = ""
+	}
+	shorten := func(i int, ext string) {
+		base := strings.TrimSuffix(parts[i], ext)
+		room := p.Len(base) - (p.Len(path) - limit)
+		short := p.trim(p.truncate(base, max(room, 1)))
+		if short == "" {
+			short = p.Replacement
+		}
+		parts[i] = short + ext
+		path = filepath.Join(append([]string{dir}, parts...)...)
+	}
+	for i := last; i >= 0 && p.Len(path) > limit; i-- {
+		if i == last {
+			shorten(i, ext)
+		} else {
+			shorten(i, "")
+		}
+	}
+	if p.Len(path) > limit && ext != "" {
+		shorten(last, "")
+	}
+	return path
+}
+
+// Key returns the form of path used to compare paths for collisions on
+// this filesystem.
+func (p Policy) Key(path string) string {
+	path = norm.NFC.String(filepath.Clean(path))
+	if p.CaseInsensitive {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// Len measures s in the policy's unit.
+func (p Policy) Len(s string) int {
+	if p.Unit == UTF16 {
+		n := 0
+		for _, r := range s {
+			n += utf16.RuneLen(r)
+		}
+		return n
+	}
+	return len(s)
+}
+
+// truncate shortens s to at most max units without splitting a rune.
+func (p Policy) truncate(s string, max int) string {
+	if p.Len(s) <= max {
+		return s
+	}
+	n := 0
+	for i, r := range s {
+		w := utf8.RuneLen(r)
+		if p.Unit == UTF16 {
+			w = utf16.RuneLen(r)
+		}
+		if n+w > max {
+			return s[:i]
+		}
+		n += w
+	}
+	return s
+}
+
+func (p Policy) trim(s string) string {
+	s = strings.TrimSpace(s)
+	if p.TrimTrailingDot {
+		s = strings.TrimRight(s, ". ")
+	}
+	return s
+}
+
+func (p Policy) normalize(s string) string {
+	switch p.Form {
+	case NFC:
+		return norm.NFC.String(s)
+	case NFD:
+		return norm.NFD.String(s)
+	}
+	return s
+}
+
+// Name sanitizes s with the Default policy.
+func Name(s string) string { return Default.Name(s) }
+
+// File sanitizes base with the Default policy and appends ext.
+func File(base, ext string) string { return Default.File(base, ext) }
+
+// Join joins dir and the sanitized elements with the Default policy.
+func Join(dir string, elem ...string) string { return Default.Join(dir, elem...) }
@@ -0,0 +1,160 @@
+package safepath
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		policy Policy
+		in     string
+		want   string
+	}{
+		{Windows, `AC/DC: Live?`, "AC_DC_ Live_"},
+		{POSIX, `AC/DC: Live?`, "AC_DC: Live?"},
+		{Windows, "Tab\there", "Tab_here"},
+		{Windows, "Ends with dots...", "Ends with dots"},
+		{POSIX, "Ends with dots...", "Ends with dots..."},
+		{Windows, "con", "_con"},
+		{Windows, "Nul.txt", "_Nul.txt"},
+		{POSIX, "con", "con"},
+		{Windows, "..", "_"},
+		{Windows, "   ", "_"},
+		{Windows, "e\u0301", "\u00e9"},
+		{withForm(t, Windows, NFD), "\u00e9", "e\u0301"},
+		{Windows, strings.Repeat("a", 300), strings.Repeat("a", 255)},
+		{POSIX, strings.Repeat("é", 200), strings.Repeat("é", 127)},
+		{Windows, strings.Repeat("é", 200), strings.Repeat("é", 200)},
+		{Windows, strings.Repeat("😀", 200), strings.Repeat("😀", 127)},
+		{Policy{Replacement: "_"}, strings.Repeat("a", 300), strings.Repeat("a", 300)},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.Name(tt.in); got != tt.want {
+			t.Errorf("%s.Name(%q) = %q, want %q", tt.policy.ID, tt.in, got, tt.want)
+		}
+	}
+}
+
+func withForm(t *testing.T, p Policy, f Form) Policy {
+	t.Helper()
+	p, err := p.WithForm(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestFile(t *testing.T) {
+	short := Policy{ID: "short", Replacement: "_", Unit: Bytes, MaxName: 10}
+	tests := []struct {
+		policy Policy
+		base   string
+		ext    string
+		want   string
+	}{
+		{Windows, "01 Song?", ".m4a", "01 Song_.m4a"},
+		{short, "0123456789", ".m4a", "012345.m4a"},
+		{short, "01234 6789", ".m4a", "01234.m4a"},
+		{short, "song", ".m4a", "song.m4a"},
+		// the extension alone fills the limit
+		{short, "song", ".0123456789", "song.01234"},
+		{short, "song", ".012345678901", "song.01234"},
+		{Policy{Replacement: "_"}, strings.Repeat("a", 300), ".m4a", strings.Repeat("a", 300) + ".m4a"},
+	}
+	for _, tt := range tests {
+		got := tt.policy.File(tt.base, tt.ext)
+		if got != tt.want {
+			t.Errorf("%s.File(%q, %q) = %q, want %q", tt.policy.ID, tt.base, tt.ext, got, tt.want)
+		}
+		if tt.policy.MaxName > 0 && tt.policy.Len(got) > tt.policy.MaxName {
+			t.Errorf("%s.File(%q, %q) = %q is over the limit", tt.policy.ID, tt.base, tt.ext, got)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	p := Policy{ID: "short", Forbidden: "/", Replacement: "_", Unit: Bytes, MaxName: 255, MaxPath: 31}
+	dir := filepath.FromSlash("/root/music") // 11 bytes
+	tests := []struct {
+		name string
+		elem []string
+		want string
+	}{
+		{"fits", []string{"Artist", "Song.m4a"}, filepath.Join(dir, "Artist", "Song.m4a")},
+		{"last shortened", []string{"Artist", "A very long song title.m4a"}, filepath.Join(dir, "Artist", "A very l.m4a")},
+		{"artist shortened too", []string{"Artist name is long", "Song.m4a"}, filepath.Join(dir, "Artist name i", "S.m4a")},
+		{"earlier shortened", []string{"An artist with a very long name", "Song.m4a"}, filepath.Join(dir, "An artist wit", "S.m4a")},
+		{"sanitized", []string{"AC/DC", "Song.m4a"}, filepath.Join(dir, "AC_DC", "Song.m4a")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := p.Join(dir, tt.elem...)
+			if got != tt.want {
+				t.Errorf("Join() = %q, want %q", got, tt.want)
+			}
+			if p.Len(got) > p.MaxPath {
+				t.Errorf("Join() = %q is %d bytes, over the limit", got, p.Len(got))
+			}
+		})
+	}
+}
+
+func TestJoinLongDir(t *testing.T) {
+	p := Policy{ID: "short", Replacement: "_", Unit: Bytes, MaxPath: 10}
+	dir := filepath.FromSlash("/" + strings.Repeat("d", 20))
+	got := p.Join(dir, "Artist", "Song.m4a")
+	if want := filepath.Join(dir, "A", "S"); got != want {
+		t.Errorf("Join() = %q, want %q", got, want)
+	}
+}
+
+func TestJoinRelativeDir(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Policy{ID: "short", Replacement: "_", Unit: Bytes, MaxPath: len(cwd) + 20}
+	got := p.Join("music", "Artist", "A long song title.m4a")
+	abs, err := filepath.Abs(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Len(abs) > p.MaxPath {
+		t.Errorf("Join() = %q is %d bytes as an absolute path, over the limit", abs, p.Len(abs))
+	}
+	if want := filepath.Join("music", "Artist", "A.m4a"); got != want {
+		t.Errorf("Join() = %q, want %q", got, want)
+	}
+}
+
+func TestKey(t *testing.T) {
+	if Windows.Key("Music/Song.m4a") != Windows.Key("music/song.M4A") {
+		t.Error("Windows keys differ by case")
+	}
+	if POSIX.Key("Music/Song.m4a") == POSIX.Key("music/song.m4a") {
+		t.Error("POSIX keys ignore case")
+	}
+	if POSIX.Key("Caf\u00e9") != POSIX.Key("Cafe\u0301") {
+		t.Error("keys differ by normalization")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"", "windows", "POSIX", "smb", "fat32"} {
+		if _, err := Lookup(name); err != nil {
+			t.Errorf("Lookup(%q): %v", name, err)
+		}
+	}
+	if p, _ := Lookup("FAT32"); p != Windows {
+		t.Errorf("Lookup(FAT32) = %+v, want the windows policy", p)
+	}
+	if _, err := Lookup("ntfs"); err == nil {
+		t.Error("Lookup(ntfs) succeeded")
+	}
+	if _, err := Windows.WithForm("nfkc"); err == nil {
+		t.Error("WithForm(nfkc) succeeded")
+	}
+}
@@ -5,14 +5,24 @@ type ConfigSet struct {
 	MediaUserToken          string `yaml:"media-user-token"`
 	AuthorizationToken      string `yaml:"authorization-token"`
 	Language                string `yaml:"language"`
+	SecondaryLanguage       string `yaml:"secondary-language"`
+	SecondaryLanguageTags   string `yaml:"secondary-language-tags"`
 	SaveLrcFile             bool   `yaml:"save-lrc-file"`
 	LrcType                 string `yaml:"lrc-type"`
-	LrcFormat               string `yaml:"lrc-format"`
+	LrcLayerMode            string `yaml:"lrc-layer-mode"`
+	LrcTranslationLanguage  string `yaml:"lrc-translation-language"`
+	LyricsFolder            string `yaml:"lyrics-folder"`
+	LyricsURL               string `yaml:"lyrics-url"`
+	LrcOffset               int    `yaml:"lrc-offset"`
+	LrcValidation           string `yaml:"lrc-validation"`
 	SaveAnimatedArtwork     bool   `yaml:"save-animated-artwork"`
 	EmbyAnimatedArtwork     bool   `yaml:"emby-animated-artwork"`
 	EmbedLrc                bool   `yaml:"embed-lrc"`
 	EmbedCover              bool   `yaml:"embed-cover"`
 	SaveArtistCover         bool   `yaml:"save-artist-cover"`
+	SaveNfo                 bool   `yaml:"save-nfo"`
+	SaveAlbumJson           bool   `yaml:"save-album-json"`
+	SaveNotes               bool   `yaml:"save-notes"`
 	CoverSize               string `yaml:"cover-size"`
 	CoverFormat             string `yaml:"cover-format"`
 	AlacSaveFolder          string `yaml:"alac-save-folder"`
@@ -22,6 +32,21 @@ type ConfigSet struct {
 	PlaylistFolderFormat    string `yaml:"playlist-folder-format"`
 	ArtistFolderFormat      string `yaml:"artist-folder-format"`
 	SongFileFormat          string `yaml:"song-file-format"`
+	DiscFolderFormat        string `yaml:"disc-folder-format"`
+	DiscAwareNumbering      bool   `yaml:"disc-aware-numbering"`
+	PathPolicy              string `yaml:"path-policy"`
+	PathUnicodeForm         string `yaml:"path-unicode-form"`
+	CollisionStrategy       string `yaml:"collision-strategy"`
+	ReportFile              string `yaml:"report-file"`
+	PlaylistMode            string `yaml:"playlist-mode"`
+	PlaylistSyncFolder      string `yaml:"playlist-sync-folder"`
+	PlaylistSyncArchive     bool   `yaml:"playlist-sync-archive"`
+	HistoryFile             string `yaml:"history-file"`
+	SkipOwned               bool   `yaml:"skip-owned"`
+	ChecksumManifest        bool   `yaml:"checksum-manifest"`
+	GenreMode               string `yaml:"genre-mode"`
+	GenreSeparator          string `yaml:"genre-separator"`
+	GenreFallback           string `yaml:"genre-fallback"`
 	ExplicitChoice          string `yaml:"explicit-choice"`
 	CleanChoice             string `yaml:"clean-choice"`
 	AppleMasterChoice       string `yaml:"apple-master-choice"`
@@ -38,6 +63,38 @@ type ConfigSet struct {
 	DlAlbumcoverForPlaylist bool   `yaml:"dl-albumcover-for-playlist"`
 	MVAudioType             string `yaml:"mv-audio-type"`
 	MVMax                   int    `yaml:"mv-max"`
+	MP4BoxPath              string `yaml:"mp4box-path"`
+	Mp4DecryptPath          string `yaml:"mp4decrypt-path"`
+	FFmpegPath              string `yaml:"ffmpeg-path"`
+	FakeTools               bool   `yaml:"fake-tools"`
+
+	PlaylistFormats []string          `yaml:"playlist-formats"`
+	GenreBlacklist  []string          `yaml:"genre-blacklist"`
+	GenreMap        map[string]string `yaml:"genre-map"`
+	LrcFormat       StringList        `yaml:"lrc-format"`
+	LrcLayers       StringList        `yaml:"lrc-layers"`
+	LyricsProviders StringList        `yaml:"lyrics-providers"`
+	LrcTrackOffsets map[string]int    `yaml:"lrc-track-offsets"`
+}
+
+// StringList is a list that may also be written as a single YAML string.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*l = nil
+		if s != "" {
+			*l = StringList{s}
+		}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
 }
 
 type Counter struct {
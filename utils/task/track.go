@@ -22,8 +22,12 @@ type Track struct {
 	DeviceM3u8 string
 	Quality    string
 	CoverPath  string
+	NameSuffix string // set when the planned file name collides with another track
+	PrevPath   string // earlier file of a synced playlist track, renamed to SavePath after the sync
+	SyncPath   string // where syncPlaylist moves a track downloaded under a temporary name
 
 	Resp         ampapi.TrackRespData
+	Secondary    Names  // names in the secondary language, if one is set
 	PreType      string // 上级类型 专辑或者歌单
 	PreID        string // 上级ID
 	DiscTotal    int
@@ -31,6 +35,15 @@ type Track struct {
 	PlaylistData ampapi.PlaylistRespData
 }
 
+// Names are the translatable names of a track.
+type Names struct {
+	Name            string
+	ArtistName      string
+	AlbumName       string
+	AlbumArtistName string
+	ComposerName    string
+}
+
 func (t *Track) GetAlbumData(token string) error {
 	var err error
 	resp, err := ampapi.GetAlbumRespByHref(t.Resp.Href, t.Language, token)
@@ -0,0 +1,117 @@
+package tools
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Call records one invocation of a fake tool.
+type Call struct {
+	Tool string
+	Args []string
+}
+
+// Fake stands in for every tool so the pipeline can run without the
+// binaries installed. Outputs are produced by copying the first input, and
+// every call is recorded. Setting Err makes all calls fail with it.
+type Fake struct {
+	Err error
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// NewFakeSet returns a Set backed by f.
+func NewFakeSet(f *Fake) *Set {
+	return &Set{
+		MP4Box:     fakeMP4Box{f},
+		Mp4Decrypt: fakeMp4Decrypt{f},
+		FFmpeg:     fakeFFmpeg{f},
+	}
+}
+
+// Calls returns the invocations recorded so far.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+func (f *Fake) record(tool string, args ...string) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, Call{Tool: tool, Args: args})
+	f.mu.Unlock()
+	return f.Err
+}
+
+func (f *Fake) status(name string) Status {
+	return Status{Name: name, Path: "fake", Version: "fake", Err: f.Err}
+}
+
+func copyFile(in, out string) error {
+	src, err := os.Open(in)
+	if err != nil {
+		// Inputs such as stream URLs do not exist locally; leave an empty file.
+		return os.WriteFile(out, nil, 0644)
+	}
+	defer src.Close()
+	dst, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+type fakeMP4Box struct{ f *Fake }
+
+func (m fakeMP4Box) Name() string   { return "MP4Box" }
+func (m fakeMP4Box) Status() Status { return m.f.status(m.Name()) }
+
+func (m fakeMP4Box) ITags(file string, tags []string) error {
+	return m.f.record(m.Name(), "-itags", strings.Join(tags, ":"), file)
+}
+
+func (m fakeMP4Box) Mux(tags []string, out string, inputs ...string) error {
+	if err := m.f.record(m.Name(), append([]string{"-itags", strings.Join(tags, ":"), "-new", out}, inputs...)...); err != nil {
+		return err
+	}
+	if len(inputs) == 0 {
+		return os.WriteFile(out, nil, 0644)
+	}
+	return copyFile(inputs[0], out)
+}
+
+type fakeMp4Decrypt struct{ f *Fake }
+
+func (m fakeMp4Decrypt) Name() string   { return "mp4decrypt" }
+func (m fakeMp4Decrypt) Status() Status { return m.f.status(m.Name()) }
+
+func (m fakeMp4Decrypt) Decrypt(key string, in string, out string) error {
+	if err := m.f.record(m.Name(), "--key", key, in, out); err != nil {
+		return err
+	}
+	return copyFile(in, out)
+}
+
+type fakeFFmpeg struct{ f *Fake }
+
+func (m fakeFFmpeg) Name() string   { return "ffmpeg" }
+func (m fakeFFmpeg) Status() Status { return m.f.status(m.Name()) }
+
+func (m fakeFFmpeg) Copy(input string, out string) error {
+	if err := m.f.record(m.Name(), "-i", input, "-c", "copy", out); err != nil {
+		return err
+	}
+	return copyFile(input, out)
+}
+
+func (m fakeFFmpeg) Gif(input string, out string, width int, fps int) error {
+	if err := m.f.record(m.Name(), "-i", input, "-f", "gif", out); err != nil {
+		return err
+	}
+	return copyFile(input, out)
+}
@@ -0,0 +1,34 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+const minFFmpeg = "4.0"
+
+var ffmpegVersion = regexp.MustCompile(`version n?(\d+(?:\.\d+)*)`)
+
+type ffmpeg struct {
+	*binary
+}
+
+func newFFmpeg(path string) *ffmpeg {
+	return &ffmpeg{newBinary("ffmpeg", path, minFFmpeg, func(path string) (string, error) {
+		v, err := versionOutput(path, ffmpegVersion, "-version")
+		if err != nil {
+			// Git snapshots report "version N-12345-g..." and cannot be compared.
+			return "", nil
+		}
+		return v, nil
+	})}
+}
+
+func (f *ffmpeg) Copy(input string, out string) error {
+	return f.run("", "-loglevel", "quiet", "-y", "-i", input, "-c", "copy", out)
+}
+
+func (f *ffmpeg) Gif(input string, out string, width int, fps int) error {
+	return f.run("", "-y", "-i", input, "-vf", fmt.Sprintf("scale=%d:-1", width), "-r", strconv.Itoa(fps), "-f", "gif", out)
+}
@@ -0,0 +1,34 @@
+package tools
+
+import (
+	"regexp"
+	"strings"
+)
+
+// minMP4Box is the first GPAC release accepting 4CC names in -itags.
+const minMP4Box = "2.0"
+
+var mp4boxVersion = regexp.MustCompile(`GPAC version (\d+(?:\.\d+)*)`)
+
+type mp4box struct {
+	*binary
+}
+
+func newMP4Box(path string) *mp4box {
+	return &mp4box{newBinary("MP4Box", path, minMP4Box, func(path string) (string, error) {
+		return versionOutput(path, mp4boxVersion, "-version")
+	})}
+}
+
+func (m *mp4box) ITags(file string, tags []string) error {
+	return m.run("", "-itags", strings.Join(tags, ":"), file)
+}
+
+func (m *mp4box) Mux(tags []string, out string, inputs ...string) error {
+	args := []string{"-itags", strings.Join(tags, ":"), "-quiet"}
+	for _, in := range inputs {
+		args = append(args, "-add", in)
+	}
+	args = append(args, "-keep-utc", "-new", out)
+	return m.run("", args...)
+}
@@ -0,0 +1,27 @@
+package tools
+
+import (
+	"path/filepath"
+	"regexp"
+)
+
+const minMp4Decrypt = "1.5"
+
+var mp4decryptVersion = regexp.MustCompile(`Version (\d+(?:\.\d+)*)`)
+
+type mp4decrypt struct {
+	*binary
+}
+
+func newMp4Decrypt(path string) *mp4decrypt {
+	return &mp4decrypt{newBinary("mp4decrypt", path, minMp4Decrypt, func(path string) (string, error) {
+		// mp4decrypt has no version flag; its usage banner carries the version.
+		return versionOutput(path, mp4decryptVersion)
+	})}
+}
+
+// Decrypt runs inside the output directory, as mp4decrypt mishandles
+// non-ASCII paths on some platforms.
+func (m *mp4decrypt) Decrypt(key string, in string, out string) error {
+	return m.run(filepath.Dir(out), "--key", key, in, filepath.Base(out))
+}
@@ -0,0 +1,268 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+var leadingDigits = regexp.MustCompile(`^\d+`)
+
+// ErrNotFound is returned by a tool whose binary could not be located.
+var ErrNotFound = errors.New("binary not found")
+
+// Tool is the part every external binary wrapper has in common.
+type Tool interface {
+	Name() string
+	Status() Status
+}
+
+// MP4Box wraps GPAC's MP4Box, used for iTunes tags and MV remuxing.
+type MP4Box interface {
+	Tool
+	ITags(file string, tags []string) error
+	Mux(tags []string, out string, inputs ...string) error
+}
+
+// Mp4Decrypt wraps Bento4's mp4decrypt, used for MV and station streams.
+type Mp4Decrypt interface {
+	Tool
+	Decrypt(key string, in string, out string) error
+}
+
+// FFmpeg wraps ffmpeg, used for animated artwork.
+type FFmpeg interface {
+	Tool
+	Copy(input string, out string) error
+	Gif(input string, out string, width int, fps int) error
+}
+
+// Status describes the outcome of discovering one tool.
+type Status struct {
+	Name       string
+	Path       string
+	Version    string
+	MinVersion string
+	Err        error
+}
+
+// OK reports whether the tool was found and is recent enough.
+func (s Status) OK() bool {
+	return s.Err == nil
+}
+
+// Paths overrides the binary used for each tool. Empty means look up PATH.
+type Paths struct {
+	MP4Box     string
+	Mp4Decrypt string
+	FFmpeg     string
+}
+
+// Set groups the tools used by the download pipeline.
+type Set struct {
+	MP4Box     MP4Box
+	Mp4Decrypt Mp4Decrypt
+	FFmpeg     FFmpeg
+}
+
+// Default is the set used by the pipeline. It is replaced by Init at startup
+// and can be swapped for NewFakeSet when the binaries are not installed.
+var Default = &Set{
+	MP4Box:     newMP4Box(""),
+	Mp4Decrypt: newMp4Decrypt(""),
+	FFmpeg:     newFFmpeg(""),
+}
+
+// Discover locates every tool and checks its version.
+func Discover(p Paths) *Set {
+	s := &Set{
+		MP4Box:     newMP4Box(p.MP4Box),
+		Mp4Decrypt: newMp4Decrypt(p.Mp4Decrypt),
+		FFmpeg:     newFFmpeg(p.FFmpeg),
+	}
+	for _, t := range s.All() {
+		t.Status()
+	}
+	return s
+}
+
+// Init discovers the tools and installs them as Default.
+func Init(p Paths) *Set {
+	Default = Discover(p)
+	return Default
+}
+
+// Use installs s as Default, e.g. a fake set in CI.
+func Use(s *Set) {
+	Default = s
+}
+
+// All returns the tools in a stable order.
+func (s *Set) All() []Tool {
+	return []Tool{s.MP4Box, s.Mp4Decrypt, s.FFmpeg}
+}
+
+// Missing returns the status of every tool that is unusable.
+func (s *Set) Missing() []Status {
+	var missing []Status
+	for _, t := range s.All() {
+		if st := t.Status(); !st.OK() {
+			missing = append(missing, st)
+		}
+	}
+	return missing
+}
+
+// Doctor writes a table with the state of every tool to w and reports
+// whether all of them are usable.
+func (s *Set) Doctor(w io.Writer) bool {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Tool", "Path", "Version", "Required", "Status"})
+	ok := true
+	for _, t := range s.All() {
+		st := t.Status()
+		status := "OK"
+		if !st.OK() {
+			status = st.Err.Error()
+			ok = false
+		}
+		required := ""
+		if st.MinVersion != "" {
+			required = ">= " + st.MinVersion
+		}
+		table.Append([]string{st.Name, st.Path, st.Version, required, status})
+	}
+	table.Render()
+	return ok
+}
+
+// binary holds the lookup and version state shared by the real tools. The
+// lookup runs once, even when tracks are processed concurrently.
+type binary struct {
+	name       string
+	path       string
+	minVersion string
+	once       sync.Once
+	status     Status
+	probe      func(path string) (string, error)
+}
+
+func newBinary(name, path, minVersion string, probe func(path string) (string, error)) *binary {
+	if path == "" {
+		path = name
+	}
+	return &binary{name: name, path: path, minVersion: minVersion, probe: probe}
+}
+
+func (b *binary) Name() string {
+	return b.name
+}
+
+func (b *binary) Status() Status {
+	b.once.Do(func() {
+		b.status = b.discover()
+	})
+	return b.status
+}
+
+// discover locates the binary and checks its version.
+func (b *binary) discover() Status {
+	st := Status{Name: b.name, MinVersion: b.minVersion}
+	path, err := exec.LookPath(b.path)
+	if err != nil {
+		st.Err = fmt.Errorf("%s: %w", b.path, ErrNotFound)
+		return st
+	}
+	st.Path = path
+	if b.probe == nil {
+		return st
+	}
+	version, err := b.probe(path)
+	if err != nil {
+		st.Err = fmt.Errorf("failed to read %s version: %w", b.name, err)
+		return st
+	}
+	st.Version = version
+	if version != "" && compareVersions(version, b.minVersion) < 0 {
+		st.Err = fmt.Errorf("%s %s is older than the required %s", b.name, version, b.minVersion)
+	}
+	return st
+}
+
+// command returns a command for the tool, or an error if it is unusable.
+func (b *binary) command(args ...string) (*exec.Cmd, error) {
+	st := b.Status()
+	if st.Err != nil {
+		return nil, st.Err
+	}
+	return exec.Command(st.Path, args...), nil
+}
+
+// run executes the tool and wraps failures with its combined output.
+func (b *binary) run(dir string, args ...string) error {
+	cmd, err := b.command(args...)
+	if err != nil {
+		return err
+	}
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w\n%s", b.name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// versionOutput runs the binary and extracts a version with re. Some tools
+// exit non-zero when printing their banner, so the exit status is ignored.
+func versionOutput(path string, re *regexp.Regexp, args ...string) (string, error) {
+	out, _ := exec.Command(path, args...).CombinedOutput()
+	m := re.FindSubmatch(out)
+	if m == nil {
+		return "", errors.New("unrecognised version output")
+	}
+	return string(m[1]), nil
+}
+
+// compareVersions compares dotted numeric versions, ignoring any suffix.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		digits := leadingDigits.FindString(p)
+		if digits == "" {
+			break
+		}
+		n, _ := strconv.Atoi(digits)
+		parts = append(parts, n)
+		if len(digits) != len(p) {
+			break
+		}
+	}
+	return parts
+}
@@ -0,0 +1,179 @@
+package tools
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2.0", "2.0", 0},
+		{"2.0.0", "2.0", 0},
+		{"2.2.1", "2.0", 1},
+		{"1.9", "2.0", -1},
+		{"10.0", "9.9", 1},
+		{"2.3-DEV", "2.3", 0},
+		{"4.4.2-0ubuntu", "4.0", 1},
+		{"1.6.0-641", "1.6", 0},
+		{"", "1.0", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// executable returns a path that exec.LookPath accepts.
+func executable(t *testing.T) string {
+	t.Helper()
+	path, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	return path
+}
+
+func TestBinaryStatus(t *testing.T) {
+	exe := executable(t)
+	tests := []struct {
+		name    string
+		path    string
+		version string
+		err     error
+		wantErr string
+	}{
+		{name: "recent", path: exe, version: "2.2.1"},
+		{name: "unknown version", path: exe, version: ""},
+		{name: "old", path: exe, version: "1.8", wantErr: "older than the required 2.0"},
+		{name: "unreadable", path: exe, err: errors.New("boom"), wantErr: "failed to read tool version: boom"},
+		{name: "missing", path: filepath.Join(t.TempDir(), "missing"), wantErr: ErrNotFound.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBinary("tool", tt.path, "2.0", func(string) (string, error) {
+				return tt.version, tt.err
+			})
+			st := b.Status()
+			if tt.wantErr == "" {
+				if !st.OK() {
+					t.Fatalf("Status() = %v, want OK", st.Err)
+				}
+				if st.Version != tt.version {
+					t.Errorf("Version = %q, want %q", st.Version, tt.version)
+				}
+				return
+			}
+			if st.OK() || !strings.Contains(st.Err.Error(), tt.wantErr) {
+				t.Errorf("Status().Err = %v, want %q", st.Err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBinaryStatusProbesOnce(t *testing.T) {
+	var probes atomic.Int32
+	b := newBinary("tool", executable(t), "1.0", func(string) (string, error) {
+		probes.Add(1)
+		return "1.0", nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if st := b.Status(); !st.OK() {
+				t.Error(st.Err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := probes.Load(); n != 1 {
+		t.Errorf("probed %d times, want 1", n)
+	}
+}
+
+func TestVersionOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	tests := []struct {
+		tool   func(path string) Tool
+		banner string
+		want   string
+	}{
+		{func(p string) Tool { return newMP4Box(p) }, "MP4Box - GPAC version 2.2.1-rev0-gb34e3851-release-2.2", "2.2.1"},
+		{func(p string) Tool { return newMp4Decrypt(p) }, "MP4 Decrypter - Version 1.6.0\\n(Bento4 Version 1.6.0.641)", "1.6.0"},
+		{func(p string) Tool { return newFFmpeg(p) }, "ffmpeg version n6.1.1 Copyright (c) 2000-2023", "6.1.1"},
+		{func(p string) Tool { return newFFmpeg(p) }, "ffmpeg version N-113087-g0c1e2f8 Copyright", ""},
+	}
+	for _, tt := range tests {
+		script := filepath.Join(t.TempDir(), "tool")
+		if err := os.WriteFile(script, []byte("#!/bin/sh\nprintf '"+tt.banner+"\\n'\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		st := tt.tool(script).Status()
+		if !st.OK() {
+			t.Errorf("%s: Status() = %v", st.Name, st.Err)
+			continue
+		}
+		if st.Version != tt.want {
+			t.Errorf("%s: Version = %q, want %q", st.Name, st.Version, tt.want)
+		}
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	var out bytes.Buffer
+	if !NewFakeSet(&Fake{}).Doctor(&out) {
+		t.Fatalf("Doctor() = false with a working fake set\n%s", out.String())
+	}
+	for _, name := range []string{"MP4Box", "mp4decrypt", "ffmpeg"} {
+		if !strings.Contains(out.String(), name) {
+			t.Errorf("Doctor output lacks %s:\n%s", name, out.String())
+		}
+	}
+
+	out.Reset()
+	fake := &Fake{Err: errors.New("not installed")}
+	if NewFakeSet(fake).Doctor(&out) {
+		t.Error("Doctor() = true with a failing fake set")
+	}
+	if n := strings.Count(out.String(), "not installed"); n != 3 {
+		t.Errorf("Doctor output reports %d failures, want 3:\n%s", n, out.String())
+	}
+}
+
+func TestFakeRecordsCalls(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.mp4")
+	if err := os.WriteFile(in, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fake := &Fake{}
+	set := NewFakeSet(fake)
+	out := filepath.Join(dir, "out.mp4")
+	if err := set.Mp4Decrypt.Decrypt("1:key", in, out); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.MP4Box.ITags(out, []string{"tool=test"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(out); err != nil || string(data) != "data" {
+		t.Errorf("decrypted file = %q, %v; want a copy of the input", data, err)
+	}
+	calls := fake.Calls()
+	if len(calls) != 2 || calls[0].Tool != "mp4decrypt" || calls[1].Tool != "MP4Box" {
+		t.Errorf("Calls() = %v", calls)
+	}
+}